@@ -1,14 +1,23 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	"github.com/hyperledger/fabric/protos/peer"
 )
 
+var ErrorNotFound = fmt.Sprint("record not found")
+
+// 资产以组合键 asset[id] 存储，避免与其他类型的记录冲突
+const assetObjectType = "asset"
+
 type AssetFactory struct {
 }
 
@@ -28,12 +37,46 @@ type Asset struct {
 	HouseCert string `json:"houseCert"`//房产证
 	LandCert string `json:"landCert"`//土地证
 	AssetsUsage string `json:"assetsUsage"`//房屋用途
-	IsMortgage string `json:"isMortgage"`//是否抵押
+	IsMortgage string `json:"isMortgage"`//是否抵押，由抵押记录推导
 	Account string `json:"account"`//账内或代管
 	LocationDetail string `json:"locationDetail"`//坐落位置
 	Longitude string `json:"longitude"`//经度
 	Latitude string `json:"latitude"`//纬度
 	Remark string `json:"remark"`//备注
+	OriginalValue string `json:"originalValue"`//原值，元，最多两位小数
+	Geo *GeoPoint `json:"geo,omitempty"`//由经纬度生成，用于范围查询，不需要传入
+	Owner *Owner `json:"owner,omitempty"`//所有者，创建时取调用者身份，不需要传入
+	Version int64 `json:"version"`//版本，每次写入加 1，不需要传入
+}
+
+type Pagination struct {
+	Bookmark string `json:"bookmark"`
+	PageSize int32  `json:"pageSize"`
+}
+
+type AssetQuery struct {
+	Pagination
+	AssetType     string `json:"assetType"`
+	Location      string `json:"location"`
+	BuildingType  string `json:"buildingType"`
+	IsMortgage    string `json:"isMortgage"`
+	Account       string `json:"account"`
+	BuildYearFrom string `json:"buildYearFrom"`
+	BuildYearTo   string `json:"buildYearTo"`
+}
+
+type AssetHistory struct {
+	TxId      string        `json:"txId"`
+	Timestamp string        `json:"timestamp"`
+	IsDelete  bool          `json:"isDelete"`
+	Value     *Asset        `json:"value"`
+	Diff      []FieldChange `json:"diff,omitempty"`
+}
+
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
 }
 
 // Init is called during chaincode instantiation to initialize any
@@ -44,28 +87,124 @@ func (t AssetFactory) Init(stub shim.ChaincodeStubInterface) peer.Response {
 }
 
 func (t AssetFactory) Invoke(stub shim.ChaincodeStubInterface) peer.Response {
+	ts := newTxStub(stub)
 	// Extract the function and args from the transaction proposal
-	fn, args := stub.GetFunctionAndParameters()
+	fn, args := ts.GetFunctionAndParameters()
+	res := t.invoke(ts, fn, args)
+	if res.Status >= shim.ERRORTHRESHOLD {
+		return res
+	}
+	// 交易中修改的资产合并为一个事件
+	err := ts.changeLog.emit(ts, fn)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return res
+}
+
+func (t AssetFactory) invoke(stub shim.ChaincodeStubInterface, fn string, args []string) peer.Response {
 	switch fn {
 	case "add":
 		return add(stub, args)
 	case "getById":
 		return getById(stub, args)
+	case "getByAssetNo":
+		return getByAssetNo(stub, args)
+	case "batchAdd":
+		return batchAdd(stub, args)
 	case "update":
 		return update(stub, args)
 	case "patch":
 		return patch(stub, args)
+	case "jsonPatch":
+		return jsonPatch(stub, args)
+	case "getHistoryById":
+		return getHistoryById(stub, args)
+	case "listAssets":
+		return listAssets(stub, args)
+	case "migrateKeys":
+		return migrateKeys(stub, args)
+	case "queryAssets":
+		return queryAssets(stub, args)
+	case "queryAssetsInBox":
+		return queryAssetsInBox(stub, args)
+	case "queryAssetsNearby":
+		return queryAssetsNearby(stub, args)
+	case "registerMortgage":
+		return registerMortgage(stub, args)
+	case "releaseMortgage":
+		return releaseMortgage(stub, args)
+	case "getMortgagesByAssetId":
+		return getMortgagesByAssetId(stub, args)
+	case "addLease":
+		return addLease(stub, args)
+	case "terminateLease":
+		return terminateLease(stub, args)
+	case "getLeasesByAssetId":
+		return getLeasesByAssetId(stub, args)
+	case "getOccupancyByAssetId":
+		return getOccupancyByAssetId(stub, args)
+	case "getOccupancyByLocation":
+		return getOccupancyByLocation(stub, args)
+	case "proposeTransfer":
+		return proposeTransfer(stub, args)
+	case "acceptTransfer":
+		return acceptTransfer(stub, args)
+	case "cancelTransfer":
+		return cancelTransfer(stub, args)
+	case "getTransferByAssetId":
+		return getTransferByAssetId(stub, args)
+	case "assignOwner":
+		return assignOwner(stub, args)
+	case "attachCertificate":
+		return attachCertificate(stub, args)
+	case "verifyCertificate":
+		return verifyCertificate(stub, args)
+	case "getCertificatesByAssetId":
+		return getCertificatesByAssetId(stub, args)
+	case "setDepreciationSchedule":
+		return setDepreciationSchedule(stub, args)
+	case "getDepreciationSchedules":
+		return getDepreciationSchedules(stub, args)
+	case "getBookValue":
+		return getBookValue(stub, args)
+	case "getAreaStats":
+		return getAreaStats(stub, args)
+	case "rebuildAreaStats":
+		return rebuildAreaStats(stub, args)
+	case "rebuildAssetNoIndex":
+		return rebuildAssetNoIndex(stub, args)
 	default:
 		return shim.Error("unsupported method " + fn)
 	}
 }
 
+// 新增资产，id 已存在时报错，调用者成为资产的所有者
+// id string required
+// value string required Asset json，id 需与第一个参数一致
 func add(stub shim.ChaincodeStubInterface, args []string) peer.Response {
 	if len(args) != 2 {
 		return shim.Error("should have 2 args")
 	}
 	id, jsonValue := args[0], args[1]
-	err := stub.PutState(id, []byte(jsonValue))
+	asset, err := decodeAsset(id, jsonValue)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	existing, err := getAsset(stub, id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if existing != nil {
+		return shim.Error("asset " + id + " already exists")
+	}
+	// 新资产不会有抵押记录
+	asset.IsMortgage = IsMortgageNo
+	asset.Owner, err = getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putAsset(stub, asset)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -77,65 +216,435 @@ func getById(stub shim.ChaincodeStubInterface, args []string) peer.Response {
 		return shim.Error("should have 1 args")
 	}
 	id := args[0]
-	jsonValue, err := stub.GetState(id)
+	key, err := assetKey(stub, id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	jsonValue, err := stub.GetState(key)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 	return shim.Success(jsonValue)
 }
 
+// 按 id 顺序分页列出全部资产
+// bookmark string
+// pageSize int required
+// res : {data:[Asset],"bookmark": "bookmark"}
+func listAssets(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("should have 1 args")
+	}
+	argStruct := Pagination{}
+	err := json.Unmarshal([]byte(args[0]), &argStruct)
+	if err != nil {
+		return shim.Error("failed to unmarshal argStruct:" + err.Error())
+	}
+	if argStruct.PageSize <= 0 {
+		return shim.Error("pageSize should be greater than 0")
+	}
+
+	resultsIterator, responseMetadata, err := stub.GetStateByPartialCompositeKeyWithPagination(assetObjectType, []string{}, argStruct.PageSize, argStruct.Bookmark)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	buf, err := constructQueryResponseFromIterator(resultsIterator, responseMetadata.Bookmark)
+	if err != nil {
+		return shim.Error("failed to generate res" + err.Error())
+	}
+	return shim.Success(buf.Bytes())
+}
+
+// 全量更新资产，id 不存在时报错，只有所有者可以调用
+// id string required
+// value string required Asset json，id 需与第一个参数一致
+// expectedVersion string 可选，与当前版本不一致时报错
 func update(stub shim.ChaincodeStubInterface, args []string) peer.Response {
-	if len(args) != 2 {
-		return shim.Error("should have 2 args")
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("should have 2 or 3 args")
 	}
 	id, jsonValue := args[0], args[1]
-	err := stub.PutState(id, []byte(jsonValue))
+	expectedVersion := ""
+	if len(args) == 3 {
+		expectedVersion = args[2]
+	}
+	asset, err := decodeAsset(id, jsonValue)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	existing, err := getAsset(stub, id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if existing == nil {
+		return shim.Error(ErrorNotFound)
+	}
+	err = checkOwner(stub, existing)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkVersion(existing.ID, existing.Version, expectedVersion)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// isMortgage 由抵押记录推导，所有者只能通过转让修改
+	asset.IsMortgage = existing.IsMortgage
+	asset.Owner = existing.Owner
+	if asset.RentableArea != existing.RentableArea {
+		err = checkRentableArea(stub, asset)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	err = putAsset(stub, asset)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 	return shim.Success([]byte(id))
 }
 
-func patch(stub shim.ChaincodeStubInterface, args []string) peer.Response {
-	if len(args) != 2 {
-		return shim.Error("should have 2 args")
+// 严格解析 Asset json，不允许未知字段，且 id 需与 key 一致
+func decodeAsset(id string, jsonValue string) (*Asset, error) {
+	asset := Asset{}
+	decoder := json.NewDecoder(strings.NewReader(jsonValue))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&asset)
+	if err != nil {
+		return nil, fmt.Errorf("invalid asset: %s", err.Error())
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("invalid asset: unexpected data after json object")
 	}
-	id, patchValue := args[0], args[1]
+	if id == "" {
+		return nil, fmt.Errorf("id is required")
+	}
+	if asset.ID != id {
+		return nil, fmt.Errorf("id in value should be %s, get %s", id, asset.ID)
+	}
+	return &asset, nil
+}
+
+func assetKey(stub shim.ChaincodeStubInterface, id string) (string, error) {
+	return stub.CreateCompositeKey(assetObjectType, []string{id})
+}
 
+// 根据 id 获取资产，不存在时返回 nil
+func getAsset(stub shim.ChaincodeStubInterface, id string) (*Asset, error) {
+	key, err := assetKey(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	jsonVal, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if jsonVal == nil {
+		return nil, nil
+	}
 	asset := Asset{}
+	err = json.Unmarshal(jsonVal, &asset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal asset %s: %s", id, err.Error())
+	}
+	return &asset, nil
+}
 
-	jsonVal, err := stub.GetState(id)
+// 按 Asset 结构重新序列化后写入，保证存储格式统一，同时维护 assetNo 索引、面积汇总和版本，并记录变更事件
+func putAsset(stub shim.ChaincodeStubInterface, asset *Asset) error {
+	err := normalizeGeo(asset)
+	if err != nil {
+		return err
+	}
+	if asset.OriginalValue != "" {
+		_, err = parseCents("originalValue", asset.OriginalValue)
+		if err != nil {
+			return err
+		}
+	}
+	previous, err := getAsset(stub, asset.ID)
+	if err != nil {
+		return err
+	}
+	asset.Version = 1
+	if previous != nil {
+		asset.Version = previous.Version + 1
+	}
+	err = updateAssetNoIndex(stub, previous, asset)
+	if err != nil {
+		return err
+	}
+	err = updateAreaStats(stub, previous, asset)
+	if err != nil {
+		return err
+	}
+	key, err := assetKey(stub, asset.ID)
+	if err != nil {
+		return err
+	}
+	previousVal, err := stub.GetState(key)
+	if err != nil {
+		return err
+	}
+	jsonVal, err := json.Marshal(asset)
+	if err != nil {
+		return fmt.Errorf("failed to marshal asset: %s", err.Error())
+	}
+	err = stub.PutState(key, jsonVal)
+	if err != nil {
+		return err
+	}
+	return addRecordChange(stub, assetObjectType, asset.ID, previousVal, jsonVal)
+}
 
+// 根据 id 查询资产的历史版本，按上链顺序返回，迁移前以 id 为 key 的历史排在前面
+// id string required
+// withDiff string "true" 时返回相邻版本之间的字段差异
+// res : [AssetHistory]
+func getHistoryById(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("should have 1 or 2 args")
+	}
+	id := args[0]
+	withDiff := len(args) == 2 && args[1] == "true"
+	key, err := assetKey(stub, id)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
-	err = json.Unmarshal(jsonVal, &asset)
+	histories := make([]AssetHistory, 0)
+	var previous *Asset
+	for _, historyKey := range []string{id, key} {
+		historyIterator, err := stub.GetHistoryForKey(historyKey)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		for historyIterator.HasNext() {
+			modification, err := historyIterator.Next()
+			if err != nil {
+				historyIterator.Close()
+				return shim.Error("failed to get history:" + err.Error())
+			}
+			history := AssetHistory{
+				TxId:     modification.TxId,
+				IsDelete: modification.IsDelete,
+			}
+			if ts := modification.Timestamp; ts != nil {
+				history.Timestamp = time.Unix(ts.Seconds, int64(ts.Nanos)).UTC().Format(time.RFC3339Nano)
+			}
+			if !modification.IsDelete {
+				asset := Asset{}
+				err = json.Unmarshal(modification.Value, &asset)
+				if err != nil {
+					historyIterator.Close()
+					return shim.Error("failed to unmarshal asset of tx " + modification.TxId + ":" + err.Error())
+				}
+				history.Value = &asset
+			}
+			if withDiff {
+				history.Diff, err = diffAssets(previous, history.Value)
+				if err != nil {
+					historyIterator.Close()
+					return shim.Error(err.Error())
+				}
+			}
+			previous = history.Value
+			histories = append(histories, history)
+		}
+		historyIterator.Close()
+	}
 
+	res, err := json.Marshal(&histories)
 	if err != nil {
-		return shim.Error(err.Error())
+		return shim.Error("failed to marshal histories:" + err.Error())
 	}
+	return shim.Success(res)
+}
 
-	patchMap := make(map[string]string)
+// 按条件分页查询资产，条件均为精确匹配，buildYear 为闭区间且按字符串比较
+// assetType string
+// location string
+// buildingType string
+// isMortgage string
+// account string
+// buildYearFrom string
+// buildYearTo string
+// bookmark string
+// pageSize int required
+// res : {data:[Asset],"bookmark": "bookmark"}
+func queryAssets(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("should have 1 args")
+	}
+	argStruct := AssetQuery{}
+	err := json.Unmarshal([]byte(args[0]), &argStruct)
+	if err != nil {
+		return shim.Error("failed to unmarshal argStruct:" + err.Error())
+	}
+	if argStruct.PageSize <= 0 {
+		return shim.Error("pageSize should be greater than 0")
+	}
 
-	err = json.Unmarshal([]byte(patchValue), &patchMap)
+	query, err := buildAssetQuery(&argStruct)
+	if err != nil {
+		return shim.Error("failed to generate query string:" + err.Error())
+	}
 
+	resultsIterator, responseMetadata, err := stub.GetQueryResultWithPagination(query, argStruct.PageSize, argStruct.Bookmark)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
+	defer resultsIterator.Close()
+
+	buf, err := constructQueryResponseFromIterator(resultsIterator, responseMetadata.Bookmark)
+	if err != nil {
+		return shim.Error("failed to generate res" + err.Error())
+	}
+	return shim.Success(buf.Bytes())
+}
+
+// 生成 couchdb 查询语句，按已有条件选择 META-INF 中对应的索引
+// 索引字段必须都出现在 selector 中 couchdb 才会使用，因此索引只包含所选分支一定会带上的字段，其余条件在索引结果上过滤
+func buildAssetQuery(argStruct *AssetQuery) (string, error) {
+	selectMap := map[string]interface{}{
+		"assetType": map[string]interface{}{
+			"$exists": true,
+		},
+	}
+	equal := map[string]string{
+		"assetType":    argStruct.AssetType,
+		"location":     argStruct.Location,
+		"buildingType": argStruct.BuildingType,
+		"isMortgage":   argStruct.IsMortgage,
+		"account":      argStruct.Account,
+	}
+	for key, val := range equal {
+		if val != "" {
+			selectMap[key] = map[string]string{
+				"$eq": val,
+			}
+		}
+	}
+
+	buildYear := make(map[string]string)
+	if argStruct.BuildYearFrom != "" {
+		buildYear["$gte"] = argStruct.BuildYearFrom
+	}
+	if argStruct.BuildYearTo != "" {
+		buildYear["$lte"] = argStruct.BuildYearTo
+	}
+	if len(buildYear) != 0 {
+		selectMap["buildYear"] = buildYear
+	}
+
+	var index []string
+	switch {
+	case argStruct.Location != "":
+		index = []string{"_design/assetLocationDoc", "assetLocation"}
+	case argStruct.AssetType != "":
+		index = []string{"_design/assetTypeDoc", "assetType"}
+	case argStruct.Account != "":
+		index = []string{"_design/assetAccountDoc", "assetAccount"}
+	case len(buildYear) != 0:
+		index = []string{"_design/assetBuildYearDoc", "assetBuildYear"}
+	default:
+		index = []string{"_design/assetTypeDoc", "assetType"}
+	}
 
-	point := mergeStructAndMap(&asset, patchMap).(*Asset)
+	queryMap := map[string]interface{}{
+		"selector":  selectMap,
+		"use_index": index,
+	}
+	query, err := json.Marshal(&queryMap)
+	if err != nil {
+		return "", err
+	}
+	return string(query), nil
+}
+
+func constructQueryResponseFromIterator(resultsIterator shim.StateQueryIteratorInterface, bookmark string) (*bytes.Buffer, error) {
+	var buffer bytes.Buffer
+	buffer.WriteString("{\"data\":[")
+
+	bArrayMemberAlreadyWritten := false
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		// 首次不用加 "，"
+		if bArrayMemberAlreadyWritten == true {
+			buffer.WriteString(",")
+		}
+
+		// Record is a JSON object, so we write as-is
+		buffer.WriteString(string(queryResponse.Value))
+		bArrayMemberAlreadyWritten = true
+	}
+	buffer.WriteString("],")
+	buffer.WriteString("\"bookmark\":")
+	if bookmark == "" {
+		buffer.WriteString("\"\"")
+	} else {
+		buffer.WriteString(fmt.Sprintf("\"%s\"", bookmark))
+	}
+	buffer.WriteString("}")
 
-	update, err := json.Marshal(point)
+	return &buffer, nil
+}
 
-	err = stub.PutState(id, update)
+// 交易时间，作为链码中的 "当前时间"，各背书节点一致
+func getTxTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
 
+// 校验调用方传入的 expectedVersion，为空时不校验；引入版本之前写入的记录版本为 0
+func checkVersion(id string, version int64, expectedVersion string) error {
+	if expectedVersion == "" {
+		return nil
+	}
+	expected, err := strconv.ParseInt(expectedVersion, 10, 64)
 	if err != nil {
-		return shim.Error(err.Error())
+		return fmt.Errorf("expectedVersion should be an integer, get %s", expectedVersion)
+	}
+	if expected != version {
+		return fmt.Errorf("version conflict: %s is at version %d, expected %d", id, version, expected)
 	}
+	return nil
+}
 
-	return shim.Success([]byte(stub.GetTxID()))
+// 比较两个版本的资产，按 json 字段名返回有变化的字段，nil 表示记录不存在
+func diffAssets(old, new *Asset) ([]FieldChange, error) {
+	oldMap, err := assetToMap(old)
+	if err != nil {
+		return nil, err
+	}
+	newMap, err := assetToMap(new)
+	if err != nil {
+		return nil, err
+	}
+	return diffMaps(oldMap, newMap), nil
+}
 
+func assetToMap(asset *Asset) (map[string]interface{}, error) {
+	assetMap := make(map[string]interface{})
+	if asset == nil {
+		return assetMap, nil
+	}
+	jsonVal, err := json.Marshal(asset)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(jsonVal, &assetMap)
+	if err != nil {
+		return nil, err
+	}
+	return assetMap, nil
 }
 
 func mergeStructAndMap(point interface{}, jsonMap map[string]string) interface{} {
@@ -144,6 +653,9 @@ func mergeStructAndMap(point interface{}, jsonMap map[string]string) interface{}
 	for i := 0; i < orderType.NumField(); i++ {
 		field := orderType.Field(i)
 		jsonTag := field.Tag.Get("json")
+		if field.Type.Kind() != reflect.String {
+			continue
+		}
 		if val, ok := jsonMap[jsonTag]; ok {
 			orderValue.FieldByName(field.Name).SetString(val)
 		}
@@ -1,15 +1,243 @@
 package main
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/msp"
 )
 
+// MockStub 不支持 GetCreator，用 identityStub 模拟调用者身份
+type identityStub struct {
+	*shim.MockStub
+	args    []string
+	creator []byte
+}
+
+func (stub *identityStub) GetFunctionAndParameters() (string, []string) {
+	return stub.args[0], stub.args[1:]
+}
+
+func (stub *identityStub) GetCreator() ([]byte, error) {
+	return stub.creator, nil
+}
+
+var defaultCreator = newCreator("Org1MSP", "user1", nil)
+
+// 生成自签名证书作为调用者身份，attrs 按 fabric-ca 的格式写入证书扩展
+func newCreator(mspId string, commonName string, attrs map[string]string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{mspId}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if attrs != nil {
+		value, _ := json.Marshal(map[string]interface{}{"attrs": attrs})
+		template.ExtraExtensions = []pkix.Extension{{Id: asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}, Value: value}}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	identity := &msp.SerializedIdentity{
+		Mspid:   mspId,
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+	creator, err := proto.Marshal(identity)
+	if err != nil {
+		panic(err)
+	}
+	return creator
+}
+
+func invoke(mockStub *shim.MockStub, txId string, args ...string) ([]byte, error) {
+	return invokeAs(mockStub, defaultCreator, txId, args...)
+}
+
+func invokeAs(mockStub *shim.MockStub, creator []byte, txId string, args ...string) ([]byte, error) {
+	mockStub.MockTransactionStart(txId)
+	defer mockStub.MockTransactionEnd(txId)
+	res := AssetFactory{}.Invoke(&identityStub{MockStub: mockStub, args: args, creator: creator})
+	if res.Status != shim.OK {
+		return nil, errors.New(res.Message)
+	}
+	return res.Payload, nil
+}
+
 func TestAdd(t *testing.T) {
 	args := []string{"key", "value"}
 	chaincode := AssetFactory{}
 	mockStub := shim.NewMockStub("asset", chaincode)
 	res := add(mockStub, args)
 	t.Log(res)
-}
\ No newline at end of file
+}
+
+func TestDiffAssets(t *testing.T) {
+	old := &Asset{ID: "1", AssetName: "name", IsMortgage: "0"}
+	update := &Asset{ID: "1", AssetName: "name", IsMortgage: "1"}
+	changes, err := diffAssets(old, update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Field != "isMortgage" || changes[0].Old != "0" || changes[0].New != "1" {
+		t.Fatal(changes)
+	}
+
+	changes, err = diffAssets(nil, update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, change := range changes {
+		if change.Old != nil {
+			t.Fatal(change)
+		}
+	}
+}
+
+func TestDecodeAsset(t *testing.T) {
+	asset, err := decodeAsset("1", `{"id":"1","assetName":"name"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if asset.AssetName != "name" {
+		t.Fatal(asset)
+	}
+	if _, err = decodeAsset("1", `{"id":"1","unknown":"x"}`); err == nil {
+		t.Fatal("unknown field should be rejected")
+	}
+	if _, err = decodeAsset("2", `{"id":"1"}`); err == nil {
+		t.Fatal("mismatched id should be rejected")
+	}
+	if _, err = decodeAsset("1", `{"id":"1"}{"id":"1"}`); err == nil {
+		t.Fatal("trailing data should be rejected")
+	}
+}
+
+func TestBuildAssetQuery(t *testing.T) {
+	query, err := buildAssetQuery(&AssetQuery{Location: "loc", IsMortgage: "1", BuildYearFrom: "2000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `{"selector":{"assetType":{"$exists":true},"buildYear":{"$gte":"2000"},"isMortgage":{"$eq":"1"},"location":{"$eq":"loc"}},"use_index":["_design/assetLocationDoc","assetLocation"]}`
+	if query != expect {
+		t.Fatal(query)
+	}
+}
+
+// 强制使用的索引需存在，且索引字段都出现在 selector 中，否则 couchdb 不使用该索引而全量扫描
+func TestAssetQueryIndexes(t *testing.T) {
+	files, err := ioutil.ReadDir("META-INF/statedb/couchdb/indexes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexes := make(map[string][]string)
+	for _, file := range files {
+		content, err := ioutil.ReadFile("META-INF/statedb/couchdb/indexes/" + file.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		index := struct {
+			Index struct {
+				Fields []string `json:"fields"`
+			} `json:"index"`
+			Ddoc string `json:"ddoc"`
+			Name string `json:"name"`
+		}{}
+		if err = json.Unmarshal(content, &index); err != nil {
+			t.Fatal(file.Name(), err)
+		}
+		indexes["_design/"+index.Ddoc+"/"+index.Name] = index.Index.Fields
+	}
+	queries := []*AssetQuery{
+		{},
+		{Location: "loc"},
+		{Location: "loc", AssetType: "t", BuildingType: "b", IsMortgage: "1"},
+		{AssetType: "t"},
+		{AssetType: "t", IsMortgage: "1"},
+		{Account: "acc"},
+		{Account: "acc", BuildingType: "b"},
+		{BuildYearFrom: "2000"},
+		{BuildYearTo: "2010", IsMortgage: "0"},
+		{BuildingType: "b"},
+	}
+	for _, argStruct := range queries {
+		query, err := buildAssetQuery(argStruct)
+		if err != nil {
+			t.Fatal(err)
+		}
+		queryMap := struct {
+			Selector map[string]interface{} `json:"selector"`
+			UseIndex []string               `json:"use_index"`
+		}{}
+		if err = json.Unmarshal([]byte(query), &queryMap); err != nil {
+			t.Fatal(err)
+		}
+		fields, ok := indexes[strings.Join(queryMap.UseIndex, "/")]
+		if !ok {
+			t.Fatal("index not shipped", query)
+		}
+		for _, field := range fields {
+			if _, ok = queryMap.Selector[field]; !ok {
+				t.Fatal("index field "+field+" missing in selector", query)
+			}
+		}
+	}
+	box, err := buildBoxQuery(1, 2, 3, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range indexes["_design/assetGeoDoc/assetGeo"] {
+		if !strings.Contains(box, `"`+field+`"`) {
+			t.Fatal("index field "+field+" missing in selector", box)
+		}
+	}
+}
+
+func TestExpectedVersion(t *testing.T) {
+	mockStub := shim.NewMockStub("asset", new(AssetFactory))
+	if _, err := invoke(mockStub, "tx1", "add", "a1", `{"id":"a1","version":9}`); err != nil {
+		t.Fatal(err)
+	}
+	asset, _ := getAsset(mockStub, "a1")
+	if asset.Version != 1 {
+		t.Fatal("new asset should be at version 1")
+	}
+	if _, err := invoke(mockStub, "tx2", "update", "a1", `{"id":"a1","remark":"r1"}`, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invoke(mockStub, "tx3", "update", "a1", `{"id":"a1","remark":"r2"}`, "1"); err == nil || !strings.Contains(err.Error(), "version conflict") {
+		t.Fatal("stale update should be rejected", err)
+	}
+	if _, err := invoke(mockStub, "tx4", "patch", "a1", `{"remark":"r3"}`, "x"); err == nil {
+		t.Fatal("expectedVersion should be an integer")
+	}
+	if _, err := invoke(mockStub, "tx5", "patch", "a1", `{"version":5}`); err == nil {
+		t.Fatal("version should not be patched")
+	}
+	if _, err := invoke(mockStub, "tx6", "jsonPatch", "a1", `[{"op":"replace","path":"/remark","value":"r3"}]`, "2"); err != nil {
+		t.Fatal(err)
+	}
+	asset, _ = getAsset(mockStub, "a1")
+	if asset.Version != 3 || asset.Remark != "r3" {
+		t.Fatal(asset)
+	}
+}
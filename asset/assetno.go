@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// assetNo 到 id 的索引，key 为 assetNo~id 组合键，不依赖 couchdb
+const assetNoIndex = "assetNo~id"
+
+type AssetNoDuplicate struct {
+	AssetNo   string `json:"assetNo"`
+	ID        string `json:"id"`        // 未建立索引的资产
+	IndexedID string `json:"indexedId"` // 已建立索引的资产
+}
+
+// 根据资产编号获取资产
+// assetNo string required
+func getByAssetNo(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("should have 1 args")
+	}
+	assetNo := args[0]
+	if assetNo == "" {
+		return shim.Error("assetNo is required")
+	}
+	id, err := getIdByAssetNo(stub, assetNo)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if id == "" {
+		return shim.Error(ErrorNotFound)
+	}
+	key, err := assetKey(stub, id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	jsonValue, err := stub.GetState(key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if jsonValue == nil {
+		return shim.Error(ErrorNotFound)
+	}
+	return shim.Success(jsonValue)
+}
+
+// 返回使用该 assetNo 的资产 id，没有时返回空字符串
+func getIdByAssetNo(stub shim.ChaincodeStubInterface, assetNo string) (string, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(assetNoIndex, []string{assetNo})
+	if err != nil {
+		return "", err
+	}
+	defer resultsIterator.Close()
+
+	if !resultsIterator.HasNext() {
+		return "", nil
+	}
+	queryResponse, err := resultsIterator.Next()
+	if err != nil {
+		return "", err
+	}
+	_, attributes, err := stub.SplitCompositeKey(queryResponse.Key)
+	if err != nil {
+		return "", err
+	}
+	return attributes[1], nil
+}
+
+// 写入资产时校验 assetNo 唯一性并更新索引，previous 为 nil 表示新增
+// 索引缺失时（引入索引之前写入或迁移的资产）即使 assetNo 未变化也会补上
+func updateAssetNoIndex(stub shim.ChaincodeStubInterface, previous *Asset, asset *Asset) error {
+	err := indexAssetNo(stub, asset)
+	if err != nil {
+		return err
+	}
+	if previous != nil && previous.AssetNo != "" && previous.AssetNo != asset.AssetNo {
+		key, err := stub.CreateCompositeKey(assetNoIndex, []string{previous.AssetNo, previous.ID})
+		if err != nil {
+			return err
+		}
+		return stub.DelState(key)
+	}
+	return nil
+}
+
+// 为资产的 assetNo 建立索引，已被其他资产使用时报错，已有索引时不重复写入
+func indexAssetNo(stub shim.ChaincodeStubInterface, asset *Asset) error {
+	if asset.AssetNo == "" {
+		return nil
+	}
+	id, err := getIdByAssetNo(stub, asset.AssetNo)
+	if err != nil {
+		return err
+	}
+	if id == asset.ID {
+		return nil
+	}
+	if id != "" {
+		return fmt.Errorf("assetNo %s is already used by asset %s", asset.AssetNo, id)
+	}
+	key, err := stub.CreateCompositeKey(assetNoIndex, []string{asset.AssetNo, asset.ID})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, []byte{0x00})
+}
+
+// 全量扫描资产重建 assetNo 索引，需由 admin 属性为 true 的身份调用，资产较多时交易较大
+// 多个资产使用同一 assetNo 时按 id 顺序索引第一个，其余在 duplicates 中返回，需修改 assetNo 后再重建
+// res : {"indexed": 1, "duplicates": [{"assetNo","id","indexedId"}]}
+func rebuildAssetNoIndex(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 0 {
+		return shim.Error("should have 0 args")
+	}
+	err := checkAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	indexIterator, err := stub.GetStateByPartialCompositeKey(assetNoIndex, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer indexIterator.Close()
+	for indexIterator.HasNext() {
+		queryResponse, err := indexIterator.Next()
+		if err != nil {
+			return shim.Error("failed get resultsIterator:" + err.Error())
+		}
+		err = stub.DelState(queryResponse.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	assetIterator, err := stub.GetStateByPartialCompositeKey(assetObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer assetIterator.Close()
+	indexed := make(map[string]string)
+	duplicates := make([]AssetNoDuplicate, 0)
+	for assetIterator.HasNext() {
+		queryResponse, err := assetIterator.Next()
+		if err != nil {
+			return shim.Error("failed get resultsIterator:" + err.Error())
+		}
+		asset := Asset{}
+		err = json.Unmarshal(queryResponse.Value, &asset)
+		if err != nil {
+			return shim.Error("failed to unmarshal asset " + queryResponse.Key + ":" + err.Error())
+		}
+		if asset.AssetNo == "" {
+			continue
+		}
+		// 删除的索引在同一交易中仍可读到，因此按本次扫描的结果判断重复
+		if id, ok := indexed[asset.AssetNo]; ok {
+			duplicates = append(duplicates, AssetNoDuplicate{AssetNo: asset.AssetNo, ID: asset.ID, IndexedID: id})
+			continue
+		}
+		key, err := stub.CreateCompositeKey(assetNoIndex, []string{asset.AssetNo, asset.ID})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = stub.PutState(key, []byte{0x00})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		indexed[asset.AssetNo] = asset.ID
+	}
+	res, err := json.Marshal(map[string]interface{}{
+		"indexed":    len(indexed),
+		"duplicates": duplicates,
+	})
+	if err != nil {
+		return shim.Error("failed to marshal res" + err.Error())
+	}
+	return shim.Success(res)
+}
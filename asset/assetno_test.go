@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestAssetNoIndex(t *testing.T) {
+	mockStub := shim.NewMockStub("asset", new(AssetFactory))
+	if _, err := invoke(mockStub, "tx1", "add", "a1", `{"id":"a1","assetNo":"no1"}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invoke(mockStub, "tx2", "add", "a2", `{"id":"a2","assetNo":"no1"}`); err == nil {
+		t.Fatal("duplicated assetNo should be rejected")
+	}
+	if _, err := invoke(mockStub, "tx3", "add", "a2", `{"id":"a2","assetNo":"no2"}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invoke(mockStub, "tx4", "patch", "a2", `{"assetNo":"no1"}`); err == nil {
+		t.Fatal("duplicated assetNo should be rejected")
+	}
+	if _, err := invoke(mockStub, "tx5", "patch", "a1", `{"assetNo":"no3"}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invoke(mockStub, "tx6", "patch", "a2", `{"assetNo":"no1"}`); err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := invoke(mockStub, "tx7", "getByAssetNo", "no1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	asset := Asset{}
+	if err = json.Unmarshal(payload, &asset); err != nil {
+		t.Fatal(err)
+	}
+	if asset.ID != "a2" {
+		t.Fatal(asset)
+	}
+	if _, err := invoke(mockStub, "tx8", "getByAssetNo", "no2"); err == nil {
+		t.Fatal("released assetNo should not be found")
+	}
+}
+
+func TestAssetNoIndexExistingAssets(t *testing.T) {
+	mockStub := shim.NewMockStub("asset", new(AssetFactory))
+	admin := newCreator("Org1MSP", "admin", map[string]string{"admin": "true"})
+	// 引入索引之前写入的资产只有记录，没有索引
+	owner := `"owner":{"mspId":"Org1MSP","subject":"CN=user1,O=Org1MSP"}`
+	mockStub.MockTransactionStart("tx1")
+	for _, value := range []string{
+		`{"id":"a1","assetNo":"no1",` + owner + `}`,
+		`{"id":"a2","assetNo":"no2",` + owner + `}`,
+		`{"id":"a3","assetNo":"no2",` + owner + `}`,
+	} {
+		asset := Asset{}
+		_ = json.Unmarshal([]byte(value), &asset)
+		key, _ := assetKey(mockStub, asset.ID)
+		_ = mockStub.PutState(key, []byte(value))
+	}
+	mockStub.MockTransactionEnd("tx1")
+	if _, err := invoke(mockStub, "tx2", "getByAssetNo", "no1"); err == nil {
+		t.Fatal("asset without index should not be found")
+	}
+
+	// 修改其他字段时补上索引
+	if _, err := invoke(mockStub, "tx3", "patch", "a1", `{"remark":"remark"}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invoke(mockStub, "tx4", "getByAssetNo", "no1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invoke(mockStub, "tx5", "add", "a4", `{"id":"a4","assetNo":"no1"}`); err == nil {
+		t.Fatal("duplicated assetNo should be rejected")
+	}
+
+	if _, err := invoke(mockStub, "tx6", "rebuildAssetNoIndex"); err == nil {
+		t.Fatal("only admin can rebuild the index")
+	}
+	payload, err := invokeAs(mockStub, admin, "tx7", "rebuildAssetNoIndex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := struct {
+		Indexed    int                `json:"indexed"`
+		Duplicates []AssetNoDuplicate `json:"duplicates"`
+	}{}
+	_ = json.Unmarshal(payload, &res)
+	if res.Indexed != 2 || len(res.Duplicates) != 1 || res.Duplicates[0] != (AssetNoDuplicate{AssetNo: "no2", ID: "a3", IndexedID: "a2"}) {
+		t.Fatal(string(payload))
+	}
+	if id, _ := getIdByAssetNo(mockStub, "no2"); id != "a2" {
+		t.Fatal(id)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	BatchFormatJson = "json"
+	BatchFormatCsv  = "csv"
+
+	// partial 写入校验通过的行，strict 任一行不通过则全部不写入
+	BatchModePartial = "partial"
+	BatchModeStrict  = "strict"
+
+	BatchRowWritten  = "written"
+	BatchRowRejected = "rejected"
+
+	maxBatchRows = 1000
+)
+
+type BatchRowResult struct {
+	Row    int    `json:"row"` // 行号，从 1 开始，csv 不含表头
+	ID     string `json:"id"`
+	Status string `json:"status"` // written 或 rejected
+	Reason string `json:"reason,omitempty"`
+}
+
+type BatchReport struct {
+	Written  int              `json:"written"`
+	Rejected int              `json:"rejected"`
+	Rows     []BatchRowResult `json:"rows"`
+}
+
+// 批量新增资产，在一个交易中写入，调用者成为所有资产的所有者
+// payload string required json 数组 [Asset]，或带表头的 csv，表头为 Asset 的 json 字段名
+// format string json（默认）或 csv
+// mode string partial（默认）写入校验通过的行；strict 任一行不通过时全部不写入并返回错误
+// res : {"written": 0, "rejected": 0, "rows": [{"row": 1, "id": "id", "status": "written|rejected", "reason": ""}]}
+func batchAdd(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) < 1 || len(args) > 3 {
+		return shim.Error("should have 1 to 3 args")
+	}
+	payload, format, mode := args[0], BatchFormatJson, BatchModePartial
+	if len(args) > 1 && args[1] != "" {
+		format = args[1]
+	}
+	if len(args) > 2 && args[2] != "" {
+		mode = args[2]
+	}
+	if mode != BatchModePartial && mode != BatchModeStrict {
+		return shim.Error("mode should be partial or strict, get " + mode)
+	}
+
+	var rows []batchRow
+	var err error
+	switch format {
+	case BatchFormatJson:
+		rows, err = parseJsonRows(payload)
+	case BatchFormatCsv:
+		rows, err = parseCsvRows(payload)
+	default:
+		return shim.Error("format should be json or csv, get " + format)
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(rows) == 0 {
+		return shim.Error("payload has no rows")
+	}
+	if len(rows) > maxBatchRows {
+		return shim.Error(fmt.Sprintf("payload has %d rows, at most %d", len(rows), maxBatchRows))
+	}
+
+	caller, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// 同一交易内读不到刚写入的状态，批次内的重复需要单独检查
+	report := BatchReport{Rows: make([]BatchRowResult, 0, len(rows))}
+	valid := make([]*Asset, 0, len(rows))
+	ids := make(map[string]int)
+	assetNos := make(map[string]int)
+	for i, row := range rows {
+		result := BatchRowResult{Row: i + 1, Status: BatchRowRejected}
+		if row.asset != nil {
+			result.ID = row.asset.ID
+		}
+		switch {
+		case row.err != nil:
+			result.Reason = row.err.Error()
+		case ids[row.asset.ID] != 0:
+			result.Reason = fmt.Sprintf("id %s is duplicated with row %d", row.asset.ID, ids[row.asset.ID])
+		case row.asset.AssetNo != "" && assetNos[row.asset.AssetNo] != 0:
+			result.Reason = fmt.Sprintf("assetNo %s is duplicated with row %d", row.asset.AssetNo, assetNos[row.asset.AssetNo])
+		default:
+			err = validateNewAsset(stub, row.asset)
+			if err != nil {
+				result.Reason = err.Error()
+			}
+		}
+		if row.asset != nil && row.asset.ID != "" {
+			if _, ok := ids[row.asset.ID]; !ok {
+				ids[row.asset.ID] = i + 1
+			}
+		}
+		if result.Reason == "" {
+			assetNos[row.asset.AssetNo] = i + 1
+			result.Status = BatchRowWritten
+			valid = append(valid, row.asset)
+		}
+		report.Rows = append(report.Rows, result)
+	}
+	report.Written = len(valid)
+	report.Rejected = len(rows) - len(valid)
+
+	res, err := json.Marshal(&report)
+	if err != nil {
+		return shim.Error("failed to marshal report:" + err.Error())
+	}
+	if mode == BatchModeStrict && report.Rejected > 0 {
+		return shim.Error(string(res))
+	}
+
+	for _, asset := range valid {
+		asset.IsMortgage = IsMortgageNo
+		asset.Owner = caller
+		err = putAsset(stub, asset)
+		if err != nil {
+			return shim.Error("failed to write asset " + asset.ID + ":" + err.Error())
+		}
+	}
+	return shim.Success(res)
+}
+
+type batchRow struct {
+	asset *Asset
+	err   error
+}
+
+func parseJsonRows(payload string) ([]batchRow, error) {
+	rawRows := make([]json.RawMessage, 0)
+	err := json.Unmarshal([]byte(payload), &rawRows)
+	if err != nil {
+		return nil, fmt.Errorf("payload should be a json array: %s", err.Error())
+	}
+	rows := make([]batchRow, 0, len(rawRows))
+	for _, raw := range rawRows {
+		idHolder := struct {
+			ID string `json:"id"`
+		}{}
+		_ = json.Unmarshal(raw, &idHolder)
+		asset, err := decodeAsset(idHolder.ID, string(raw))
+		rows = append(rows, batchRow{asset: asset, err: err})
+	}
+	return rows, nil
+}
+
+// csv 的表头需为 Asset 中字符串字段的 json 字段名，出现未知列时整个 payload 无效
+func parseCsvRows(payload string) ([]batchRow, error) {
+	reader := csv.NewReader(strings.NewReader(payload))
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read csv header: %s", err.Error())
+	}
+	columns := make(map[string]bool)
+	assetType := reflect.TypeOf(Asset{})
+	for i := 0; i < assetType.NumField(); i++ {
+		field := assetType.Field(i)
+		if field.Type.Kind() == reflect.String {
+			columns[field.Tag.Get("json")] = true
+		}
+	}
+	seen := make(map[string]bool)
+	for i, column := range header {
+		column = strings.TrimSpace(column)
+		if !columns[column] {
+			return nil, fmt.Errorf("unknown csv column %s", column)
+		}
+		if seen[column] {
+			return nil, fmt.Errorf("duplicated csv column %s", column)
+		}
+		seen[column] = true
+		header[i] = column
+	}
+	if !seen["id"] {
+		return nil, fmt.Errorf("csv column id is required")
+	}
+
+	rows := make([]batchRow, 0)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); ok && record == nil {
+				return nil, fmt.Errorf("failed to read csv: %s", err.Error())
+			}
+			rows = append(rows, batchRow{err: err})
+			continue
+		}
+		rowMap := make(map[string]string, len(header))
+		for i, column := range header {
+			rowMap[column] = record[i]
+		}
+		asset := mergeStructAndMap(&Asset{}, rowMap).(*Asset)
+		if asset.ID == "" {
+			rows = append(rows, batchRow{asset: asset, err: fmt.Errorf("id is required")})
+			continue
+		}
+		rows = append(rows, batchRow{asset: asset})
+	}
+	return rows, nil
+}
+
+// 新增前的校验，与写入时的校验一致，保证批量写入时不会中途失败
+func validateNewAsset(stub shim.ChaincodeStubInterface, asset *Asset) error {
+	existing, err := getAsset(stub, asset.ID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("asset %s already exists", asset.ID)
+	}
+	err = normalizeGeo(asset)
+	if err != nil {
+		return err
+	}
+	if asset.OriginalValue != "" {
+		_, err = parseCents("originalValue", asset.OriginalValue)
+		if err != nil {
+			return err
+		}
+	}
+	if asset.AssetNo != "" {
+		id, err := getIdByAssetNo(stub, asset.AssetNo)
+		if err != nil {
+			return err
+		}
+		if id != "" {
+			return fmt.Errorf("assetNo %s is already used by asset %s", asset.AssetNo, id)
+		}
+	}
+	return nil
+}
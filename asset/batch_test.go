@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestBatchAdd(t *testing.T) {
+	mockStub := shim.NewMockStub("asset", new(AssetFactory))
+	if _, err := invoke(mockStub, "tx1", "add", "a1", `{"id":"a1","assetNo":"no1"}`); err != nil {
+		t.Fatal(err)
+	}
+	payload := `[{"id":"a1"},{"id":"a2","assetNo":"no1"},{"id":"a3","assetNo":"no3"},{"id":"a4","assetNo":"no3"},{"id":"a3"},{"id":"a5","unknown":""},{"id":"a6","longitude":"1"}]`
+	if _, err := invoke(mockStub, "tx2", "batchAdd", payload, "json", "strict"); err == nil {
+		t.Fatal("strict mode should reject the whole batch")
+	}
+	if asset, _ := getAsset(mockStub, "a3"); asset != nil {
+		t.Fatal("strict mode should not write any row")
+	}
+
+	res, err := invoke(mockStub, "tx3", "batchAdd", payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := BatchReport{}
+	if err = json.Unmarshal(res, &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Written != 1 || report.Rejected != 6 || report.Rows[2].Status != BatchRowWritten {
+		t.Fatal(string(res))
+	}
+	if asset, _ := getAsset(mockStub, "a3"); asset == nil || asset.Owner == nil {
+		t.Fatal("valid row should be written")
+	}
+}
+
+func TestBatchAddCsv(t *testing.T) {
+	mockStub := shim.NewMockStub("asset", new(AssetFactory))
+	payload := "id,assetNo,location\na1,no1,loc\na2,no2,\"loc, detail\"\n"
+	res, err := invoke(mockStub, "tx1", "batchAdd", payload, "csv", "strict")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := BatchReport{}
+	if err = json.Unmarshal(res, &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Written != 2 {
+		t.Fatal(string(res))
+	}
+	asset, _ := getAsset(mockStub, "a2")
+	if asset == nil || asset.Location != "loc, detail" {
+		t.Fatal(asset)
+	}
+	if _, err = invoke(mockStub, "tx2", "batchAdd", "id,owner\na3,x\n", "csv"); err == nil {
+		t.Fatal("unknown column should be rejected")
+	}
+}
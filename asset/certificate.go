@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	certificateObjectType       = "certificate"
+	assetCertificateObjectType  = "assetCertificate"
+	latestCertificateObjectType = "latestCertificate"
+
+	CertTypeHouse = "house" // 房产证
+	CertTypeLand  = "land"  // 土地证
+)
+
+type Certificate struct {
+	Hash         string `json:"hash"`                   // 证书文件 sha256，小写十六进制
+	AssetID      string `json:"assetId"`                // 资产id
+	CertType     string `json:"certType"`               // house 房产证，land 土地证
+	CertNo       string `json:"certNo"`                 // 证书编号
+	Issuer       string `json:"issuer"`                 // 发证机关
+	IssueDate    string `json:"issueDate"`              // 发证日期 yyyy-MM-dd
+	StorageURI   string `json:"storageUri"`             // 链下存储地址
+	Version      int    `json:"version"`                // 同一资产同类证书的版本，从 1 开始
+	Supersedes   string `json:"supersedes,omitempty"`   // 被替换的上一版本 hash
+	SupersededBy string `json:"supersededBy,omitempty"` // 替换本版本的新版本 hash
+	AnchorTxId   string `json:"anchorTxId"`             // 上链交易
+	AnchorTime   string `json:"anchorTime"`             // 上链时间
+}
+
+type CertificateVerification struct {
+	Hash        string       `json:"hash"`
+	Anchored    bool         `json:"anchored"`              // 是否已上链
+	Latest      bool         `json:"latest"`                // 是否为最新版本
+	LatestHash  string       `json:"latestHash,omitempty"`  // 最新版本 hash
+	Certificate *Certificate `json:"certificate,omitempty"` // 上链记录
+}
+
+// 上链证书文件，需由资产所有者调用，同一资产同类证书的上一版本会被标记为已替换，
+// 资产的 houseCert 或 landCert 同步为新的证书编号
+// hash string required 证书文件 sha256，小写十六进制
+// assetId string required
+// certType string required house 或 land
+// certNo string required
+// issuer string required
+// issueDate string required yyyy-MM-dd
+// storageUri string required
+// expectedVersion string 可选，资产的当前版本，不一致时报错
+func attachCertificate(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("should have 1 or 2 args")
+	}
+	expectedVersion := ""
+	if len(args) == 2 {
+		expectedVersion = args[1]
+	}
+	certificate := Certificate{}
+	decoder := json.NewDecoder(strings.NewReader(args[0]))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&certificate)
+	if err != nil {
+		return shim.Error("invalid certificate: " + err.Error())
+	}
+	err = validateCertificate(&certificate)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	asset, err := getAsset(stub, certificate.AssetID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if asset == nil {
+		return shim.Error("asset " + certificate.AssetID + " " + ErrorNotFound)
+	}
+	err = checkOwner(stub, asset)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkVersion(asset.ID, asset.Version, expectedVersion)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	existing, err := getCertificate(stub, certificate.Hash)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if existing != nil {
+		return shim.Error("certificate " + certificate.Hash + " is already anchored to asset " + existing.AssetID)
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	certificate.Version = 1
+	certificate.Supersedes = ""
+	certificate.SupersededBy = ""
+	certificate.AnchorTxId = stub.GetTxID()
+	certificate.AnchorTime = txTime.Format(time.RFC3339)
+
+	previous, err := getLatestCertificate(stub, certificate.AssetID, certificate.CertType)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if previous != nil {
+		certificate.Version = previous.Version + 1
+		certificate.Supersedes = previous.Hash
+		previous.SupersededBy = certificate.Hash
+		err = putCertificate(stub, previous)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	err = putCertificate(stub, &certificate)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	indexKey, err := stub.CreateCompositeKey(assetCertificateObjectType, []string{certificate.AssetID, certificate.CertType, certificate.Hash})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(indexKey, []byte{0x00})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	latestKey, err := stub.CreateCompositeKey(latestCertificateObjectType, []string{certificate.AssetID, certificate.CertType})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(latestKey, []byte(certificate.Hash))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if certificate.CertType == CertTypeHouse && asset.HouseCert != certificate.CertNo {
+		asset.HouseCert = certificate.CertNo
+		err = putAsset(stub, asset)
+	} else if certificate.CertType == CertTypeLand && asset.LandCert != certificate.CertNo {
+		asset.LandCert = certificate.CertNo
+		err = putAsset(stub, asset)
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(stub.GetTxID()))
+}
+
+// 根据文件 hash 校验证书是否已上链，以及是否已被新版本替换，未上链时 anchored 为 false
+// hash string required
+// res : {"hash", "anchored", "latest", "latestHash", "certificate": Certificate}
+func verifyCertificate(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("should have 1 args")
+	}
+	hash := strings.ToLower(args[0])
+	if !hashPattern.MatchString(hash) {
+		return shim.Error("hash should be a hex sha256, get " + args[0])
+	}
+	verification := CertificateVerification{Hash: hash}
+	certificate, err := getCertificate(stub, hash)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if certificate != nil {
+		latest, err := getLatestCertificate(stub, certificate.AssetID, certificate.CertType)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		verification.Anchored = true
+		verification.Certificate = certificate
+		if latest != nil {
+			verification.LatestHash = latest.Hash
+			verification.Latest = latest.Hash == hash
+		}
+	}
+	res, err := json.Marshal(&verification)
+	if err != nil {
+		return shim.Error("failed to marshal verification:" + err.Error())
+	}
+	return shim.Success(res)
+}
+
+// 查询资产的证书，按证书类型和版本排序
+// assetId string required
+// certType string 可选 house 或 land，为空时返回全部
+// res : [Certificate]
+func getCertificatesByAssetId(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("should have 1 or 2 args")
+	}
+	assetId := args[0]
+	if assetId == "" {
+		return shim.Error("assetId is required")
+	}
+	attributes := []string{assetId}
+	if len(args) == 2 && args[1] != "" {
+		if args[1] != CertTypeHouse && args[1] != CertTypeLand {
+			return shim.Error("certType should be house or land, get " + args[1])
+		}
+		attributes = append(attributes, args[1])
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(assetCertificateObjectType, attributes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	certificates := make([]Certificate, 0)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error("failed get resultsIterator:" + err.Error())
+		}
+		_, keyAttributes, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		certificate, err := getCertificate(stub, keyAttributes[2])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if certificate != nil {
+			certificates = append(certificates, *certificate)
+		}
+	}
+	sort.SliceStable(certificates, func(i, j int) bool {
+		if certificates[i].CertType != certificates[j].CertType {
+			return certificates[i].CertType < certificates[j].CertType
+		}
+		return certificates[i].Version < certificates[j].Version
+	})
+	res, err := json.Marshal(&certificates)
+	if err != nil {
+		return shim.Error("failed to marshal certificates:" + err.Error())
+	}
+	return shim.Success(res)
+}
+
+func validateCertificate(certificate *Certificate) error {
+	if !hashPattern.MatchString(certificate.Hash) {
+		return fmt.Errorf("hash should be a lowercase hex sha256, get %s", certificate.Hash)
+	}
+	if certificate.AssetID == "" || certificate.CertNo == "" || certificate.Issuer == "" || certificate.StorageURI == "" {
+		return fmt.Errorf("assetId, certNo, issuer and storageUri is required")
+	}
+	if certificate.CertType != CertTypeHouse && certificate.CertType != CertTypeLand {
+		return fmt.Errorf("certType should be house or land, get %s", certificate.CertType)
+	}
+	_, err := time.Parse(dateLayout, certificate.IssueDate)
+	if err != nil {
+		return fmt.Errorf("issueDate should be yyyy-MM-dd, get %s", certificate.IssueDate)
+	}
+	return nil
+}
+
+func getCertificate(stub shim.ChaincodeStubInterface, hash string) (*Certificate, error) {
+	key, err := stub.CreateCompositeKey(certificateObjectType, []string{hash})
+	if err != nil {
+		return nil, err
+	}
+	jsonVal, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if jsonVal == nil {
+		return nil, nil
+	}
+	certificate := Certificate{}
+	err = json.Unmarshal(jsonVal, &certificate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal certificate %s: %s", hash, err.Error())
+	}
+	return &certificate, nil
+}
+
+func getLatestCertificate(stub shim.ChaincodeStubInterface, assetId string, certType string) (*Certificate, error) {
+	key, err := stub.CreateCompositeKey(latestCertificateObjectType, []string{assetId, certType})
+	if err != nil {
+		return nil, err
+	}
+	hash, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if hash == nil {
+		return nil, nil
+	}
+	return getCertificate(stub, string(hash))
+}
+
+func putCertificate(stub shim.ChaincodeStubInterface, certificate *Certificate) error {
+	key, err := stub.CreateCompositeKey(certificateObjectType, []string{certificate.Hash})
+	if err != nil {
+		return err
+	}
+	jsonVal, err := json.Marshal(certificate)
+	if err != nil {
+		return fmt.Errorf("failed to marshal certificate: %s", err.Error())
+	}
+	return stub.PutState(key, jsonVal)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func certificateJson(hash string, certNo string) string {
+	return `{"hash":"` + hash + `","assetId":"a1","certType":"house","certNo":"` + certNo + `","issuer":"bureau","issueDate":"2020-01-01","storageUri":"oss://bucket/` + hash + `"}`
+}
+
+func TestCertificate(t *testing.T) {
+	mockStub := shim.NewMockStub("asset", new(AssetFactory))
+	first := sha256.Sum256([]byte("first scan"))
+	second := sha256.Sum256([]byte("second scan"))
+	firstHash, secondHash := hex.EncodeToString(first[:]), hex.EncodeToString(second[:])
+
+	if _, err := invoke(mockStub, "tx1", "add", "a1", `{"id":"a1","houseCert":"c1"}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invoke(mockStub, "tx2", "attachCertificate", certificateJson(firstHash, "c1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invoke(mockStub, "tx3", "attachCertificate", certificateJson(firstHash, "c1")); err == nil {
+		t.Fatal("anchored certificate should be rejected")
+	}
+	if _, err := invoke(mockStub, "tx4", "attachCertificate", certificateJson(secondHash, "c2")); err != nil {
+		t.Fatal(err)
+	}
+	asset, _ := getAsset(mockStub, "a1")
+	if asset.HouseCert != "c2" {
+		t.Fatal(asset.HouseCert)
+	}
+
+	payload, err := invoke(mockStub, "tx5", "verifyCertificate", firstHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verification := CertificateVerification{}
+	if err = json.Unmarshal(payload, &verification); err != nil {
+		t.Fatal(err)
+	}
+	if !verification.Anchored || verification.Latest || verification.LatestHash != secondHash || verification.Certificate.SupersededBy != secondHash {
+		t.Fatal(string(payload))
+	}
+
+	unknown := sha256.Sum256([]byte("forged scan"))
+	payload, err = invoke(mockStub, "tx6", "verifyCertificate", hex.EncodeToString(unknown[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verification = CertificateVerification{}
+	if err = json.Unmarshal(payload, &verification); err != nil {
+		t.Fatal(err)
+	}
+	if verification.Anchored {
+		t.Fatal(string(payload))
+	}
+
+	payload, err = invoke(mockStub, "tx7", "getCertificatesByAssetId", "a1", CertTypeHouse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificates := make([]Certificate, 0)
+	if err = json.Unmarshal(payload, &certificates); err != nil {
+		t.Fatal(err)
+	}
+	if len(certificates) != 2 || certificates[1].Version != 2 || certificates[1].Supersedes != firstHash {
+		t.Fatal(string(payload))
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	depreciationObjectType = "depreciationSchedule"
+
+	DepreciationStraightLine     = "straightLine"     // 年限平均法
+	DepreciationDecliningBalance = "decliningBalance" // 双倍余额递减法，最后两年改为平均摊销
+
+	maxUsefulLife = 100
+)
+
+var (
+	residualRatePattern = regexp.MustCompile(`^0(\.[0-9]{1,4})?$`)
+	// 原值最多 12 位整数，以分计算并乘以残值率时不会溢出
+	originalValuePattern = regexp.MustCompile(`^[0-9]{1,12}(\.[0-9]{1,2})?$`)
+)
+
+type DepreciationSchedule struct {
+	// 适用的固定资产类别，即资产的 assetType；方案与资产存在同一个 couchdb 中，
+	// 不能使用 assetType 作为字段名，否则会被资产的富查询选中
+	TargetAssetType string `json:"targetAssetType"`
+	Method          string `json:"method"`       // straightLine 或 decliningBalance
+	UsefulLife      int    `json:"usefulLife"`   // 使用年限
+	ResidualRate    string `json:"residualRate"` // 净残值率，如 0.05
+	UpdateTxId      string `json:"updateTxId"`   // 最后修改交易
+	UpdateTime      string `json:"updateTime"`   // 最后修改时间
+	Version         int64  `json:"version"`      // 版本，每次写入加 1
+}
+
+type BookValue struct {
+	AssetID                 string `json:"assetId"`
+	AssetType               string `json:"assetType"`
+	Method                  string `json:"method"`
+	UsefulLife              int    `json:"usefulLife"`
+	ResidualRate            string `json:"residualRate"`
+	InServiceDate           string `json:"inServiceDate"`           // 启用日期，取自 buildYear
+	AsOf                    string `json:"asOf"`                    // 计算时点，即交易时间
+	DepreciatedMonths       int    `json:"depreciatedMonths"`       // 已计提月数，启用次月开始计提
+	OriginalValue           string `json:"originalValue"`           // 原值
+	ResidualValue           string `json:"residualValue"`           // 预计净残值
+	AccumulatedDepreciation string `json:"accumulatedDepreciation"` // 累计折旧
+	NetValue                string `json:"netValue"`                // 净值
+}
+
+// 设置资产类别的折旧方案，需由 admin 属性为 true 的身份调用，已存在时覆盖
+// targetAssetType string required 适用的资产类别
+// method string required straightLine 或 decliningBalance
+// usefulLife int required 使用年限，1 到 100
+// residualRate string required 净残值率，0 到 1 之间，最多 4 位小数
+// expectedVersion string 可选，与当前版本不一致时报错
+func setDepreciationSchedule(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("should have 1 or 2 args")
+	}
+	expectedVersion := ""
+	if len(args) == 2 {
+		expectedVersion = args[1]
+	}
+	err := checkAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	schedule := DepreciationSchedule{}
+	decoder := json.NewDecoder(strings.NewReader(args[0]))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&schedule)
+	if err != nil {
+		return shim.Error("invalid depreciation schedule: " + err.Error())
+	}
+	err = validateDepreciationSchedule(&schedule)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	existing, err := getDepreciationSchedule(stub, schedule.TargetAssetType)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	var version int64
+	if existing != nil {
+		version = existing.Version
+	}
+	err = checkVersion(schedule.TargetAssetType, version, expectedVersion)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	schedule.Version = version + 1
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	schedule.UpdateTxId = stub.GetTxID()
+	schedule.UpdateTime = txTime.Format(time.RFC3339)
+	key, err := stub.CreateCompositeKey(depreciationObjectType, []string{schedule.TargetAssetType})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	jsonVal, err := json.Marshal(&schedule)
+	if err != nil {
+		return shim.Error("failed to marshal depreciation schedule:" + err.Error())
+	}
+	err = stub.PutState(key, jsonVal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(stub.GetTxID()))
+}
+
+// 查询折旧方案
+// assetType string 可选，为空时返回全部
+// res : [DepreciationSchedule]
+func getDepreciationSchedules(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) > 1 {
+		return shim.Error("should have 0 or 1 args")
+	}
+	attributes := []string{}
+	if len(args) == 1 && args[0] != "" {
+		attributes = append(attributes, args[0])
+	}
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(depreciationObjectType, attributes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	schedules := make([]DepreciationSchedule, 0)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error("failed get resultsIterator:" + err.Error())
+		}
+		schedule := DepreciationSchedule{}
+		err = json.Unmarshal(queryResponse.Value, &schedule)
+		if err != nil {
+			return shim.Error("failed to unmarshal depreciation schedule:" + err.Error())
+		}
+		schedules = append(schedules, schedule)
+	}
+	res, err := json.Marshal(&schedules)
+	if err != nil {
+		return shim.Error("failed to marshal depreciation schedules:" + err.Error())
+	}
+	return shim.Success(res)
+}
+
+// 按资产类别的折旧方案计算资产在交易时间的账面价值，金额单位为元，精确到分
+// assetId string required
+// res : BookValue
+func getBookValue(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("should have 1 args")
+	}
+	asset, err := getAsset(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if asset == nil {
+		return shim.Error(ErrorNotFound)
+	}
+	if asset.OriginalValue == "" {
+		return shim.Error("originalValue of asset " + asset.ID + " is not set")
+	}
+	originalValue, err := parseCents("originalValue", asset.OriginalValue)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	inService, err := parseInServiceDate(asset.BuildYear)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	schedule, err := getDepreciationSchedule(stub, asset.AssetType)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if schedule == nil {
+		return shim.Error("depreciation schedule of assetType " + asset.AssetType + " " + ErrorNotFound)
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	months := (txTime.Year()-inService.Year())*12 + int(txTime.Month()) - int(inService.Month())
+	if months < 0 {
+		months = 0
+	}
+	if months > schedule.UsefulLife*12 {
+		months = schedule.UsefulLife * 12
+	}
+	rate, _ := parseBasisPoints(schedule.ResidualRate)
+	residualValue := roundDiv(originalValue*rate, 10000)
+	accumulated := depreciate(schedule, originalValue, residualValue, months)
+
+	bookValue := BookValue{
+		AssetID:                 asset.ID,
+		AssetType:               asset.AssetType,
+		Method:                  schedule.Method,
+		UsefulLife:              schedule.UsefulLife,
+		ResidualRate:            schedule.ResidualRate,
+		InServiceDate:           inService.Format(dateLayout),
+		AsOf:                    txTime.Format(time.RFC3339),
+		DepreciatedMonths:       months,
+		OriginalValue:           formatCents(originalValue),
+		ResidualValue:           formatCents(residualValue),
+		AccumulatedDepreciation: formatCents(accumulated),
+		NetValue:                formatCents(originalValue - accumulated),
+	}
+	res, err := json.Marshal(&bookValue)
+	if err != nil {
+		return shim.Error("failed to marshal book value:" + err.Error())
+	}
+	return shim.Success(res)
+}
+
+// 计算已计提 months 个月的累计折旧，单位为分；年内按月平均分摊年折旧额
+func depreciate(schedule *DepreciationSchedule, originalValue int64, residualValue int64, months int) int64 {
+	depreciable := originalValue - residualValue
+	if depreciable <= 0 || months <= 0 {
+		return 0
+	}
+	lifeMonths := int64(schedule.UsefulLife) * 12
+	if schedule.Method == DepreciationStraightLine {
+		return roundDiv(depreciable*int64(months), lifeMonths)
+	}
+
+	var accumulated int64
+	for year := 0; year < schedule.UsefulLife && months > 0; year++ {
+		book := originalValue - accumulated
+		var yearAmount int64
+		if remaining := int64(schedule.UsefulLife - year); remaining <= 2 {
+			yearAmount = roundDiv(book-residualValue, remaining)
+		} else {
+			yearAmount = roundDiv(book*2, int64(schedule.UsefulLife))
+		}
+		if months >= 12 {
+			accumulated += yearAmount
+			months -= 12
+		} else {
+			accumulated += roundDiv(yearAmount*int64(months), 12)
+			months = 0
+		}
+	}
+	if accumulated > depreciable {
+		accumulated = depreciable
+	}
+	return accumulated
+}
+
+func validateDepreciationSchedule(schedule *DepreciationSchedule) error {
+	if schedule.TargetAssetType == "" {
+		return fmt.Errorf("targetAssetType is required")
+	}
+	if schedule.Method != DepreciationStraightLine && schedule.Method != DepreciationDecliningBalance {
+		return fmt.Errorf("method should be straightLine or decliningBalance, get %s", schedule.Method)
+	}
+	if schedule.UsefulLife < 1 || schedule.UsefulLife > maxUsefulLife {
+		return fmt.Errorf("usefulLife should be between 1 and %d, get %d", maxUsefulLife, schedule.UsefulLife)
+	}
+	if _, err := parseBasisPoints(schedule.ResidualRate); err != nil {
+		return err
+	}
+	return nil
+}
+
+func getDepreciationSchedule(stub shim.ChaincodeStubInterface, assetType string) (*DepreciationSchedule, error) {
+	key, err := stub.CreateCompositeKey(depreciationObjectType, []string{assetType})
+	if err != nil {
+		return nil, err
+	}
+	jsonVal, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if jsonVal == nil {
+		return nil, nil
+	}
+	schedule := DepreciationSchedule{}
+	err = json.Unmarshal(jsonVal, &schedule)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal depreciation schedule %s: %s", assetType, err.Error())
+	}
+	return &schedule, nil
+}
+
+// buildYear 为启用日期，支持 yyyy、yyyy-MM 和 yyyy-MM-dd，只有年份时按 1 月启用
+func parseInServiceDate(buildYear string) (time.Time, error) {
+	for _, layout := range []string{dateLayout, "2006-01", "2006"} {
+		if date, err := time.Parse(layout, buildYear); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("buildYear should be yyyy, yyyy-MM or yyyy-MM-dd, get %s", buildYear)
+}
+
+// 残值率转为万分之一的整数
+func parseBasisPoints(rate string) (int64, error) {
+	if !residualRatePattern.MatchString(rate) {
+		return 0, fmt.Errorf("residualRate should be a decimal between 0 and 1 with at most 4 decimals, get %s", rate)
+	}
+	fraction := strings.TrimPrefix(strings.TrimPrefix(rate, "0"), ".")
+	fraction += strings.Repeat("0", 4-len(fraction))
+	return strconv.ParseInt(fraction, 10, 64)
+}
+
+// 元转为分
+func parseCents(field string, amount string) (int64, error) {
+	if !originalValuePattern.MatchString(amount) {
+		return 0, fmt.Errorf("%s should be a non-negative number with at most 12 integer digits and 2 decimals, get %s", field, amount)
+	}
+	parts := strings.SplitN(amount, ".", 2)
+	yuan, _ := strconv.ParseInt(parts[0], 10, 64)
+	cents := int64(0)
+	if len(parts) == 2 {
+		cents, _ = strconv.ParseInt(parts[1]+strings.Repeat("0", 2-len(parts[1])), 10, 64)
+	}
+	return yuan*100 + cents, nil
+}
+
+func formatCents(cents int64) string {
+	return fmt.Sprintf("%d.%02d", cents/100, cents%100)
+}
+
+// 四舍五入的整数除法，参数均为非负数
+func roundDiv(a int64, b int64) int64 {
+	return (a + b/2) / b
+}
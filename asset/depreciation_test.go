@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestDepreciate(t *testing.T) {
+	straightLine := &DepreciationSchedule{Method: DepreciationStraightLine, UsefulLife: 10}
+	if amount := depreciate(straightLine, 12000000, 600000, 24); amount != 2280000 {
+		t.Fatal(amount)
+	}
+	if amount := depreciate(straightLine, 12000000, 600000, 120); amount != 11400000 {
+		t.Fatal(amount)
+	}
+
+	declining := &DepreciationSchedule{Method: DepreciationDecliningBalance, UsefulLife: 5}
+	expected := map[int]int64{0: 0, 12: 400000, 13: 420000, 30: 712000, 48: 872000, 60: 960000}
+	for months, accumulated := range expected {
+		if amount := depreciate(declining, 1000000, 40000, months); amount != accumulated {
+			t.Fatal(months, amount)
+		}
+	}
+}
+
+func TestGetBookValue(t *testing.T) {
+	mockStub := shim.NewMockStub("asset", new(AssetFactory))
+	admin := newCreator("Org1MSP", "admin", map[string]string{"admin": "true"})
+	schedule := `{"targetAssetType":"house","method":"straightLine","usefulLife":10,"residualRate":"0.05"}`
+	if _, err := invoke(mockStub, "tx1", "setDepreciationSchedule", schedule); err == nil {
+		t.Fatal("only admin can set depreciation schedules")
+	}
+	if _, err := invokeAs(mockStub, admin, "tx2", "setDepreciationSchedule", `{"targetAssetType":"house","method":"straightLine","usefulLife":10,"residualRate":"5%"}`); err == nil {
+		t.Fatal("invalid residualRate should be rejected")
+	}
+	if _, err := invokeAs(mockStub, admin, "tx3", "setDepreciationSchedule", schedule, "0"); err != nil {
+		t.Fatal(err)
+	}
+	// 资产的富查询以 assetType 字段区分资产，存储的方案不能带有该字段
+	key, _ := mockStub.CreateCompositeKey(depreciationObjectType, []string{"house"})
+	stored := make(map[string]interface{})
+	value, _ := mockStub.GetState(key)
+	if err := json.Unmarshal(value, &stored); err != nil || stored["assetType"] != nil || stored["targetAssetType"] != "house" {
+		t.Fatal(string(value))
+	}
+
+	buildYear := time.Now().UTC().AddDate(-2, 0, 0).Format("2006-01")
+	if _, err := invoke(mockStub, "tx4", "add", "a1", `{"id":"a1","assetType":"house","buildYear":"`+buildYear+`","originalValue":"120000"}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invoke(mockStub, "tx5", "patch", "a1", `{"originalValue":"1.234"}`); err == nil {
+		t.Fatal("originalValue should have at most 2 decimals")
+	}
+	payload, err := invoke(mockStub, "tx6", "getBookValue", "a1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bookValue := BookValue{}
+	_ = json.Unmarshal(payload, &bookValue)
+	if bookValue.DepreciatedMonths != 24 || bookValue.AccumulatedDepreciation != "22800.00" || bookValue.NetValue != "97200.00" || bookValue.ResidualValue != "6000.00" {
+		t.Fatal(string(payload))
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// fabric 每个交易只能设置一个事件：只修改一条记录时发送 recordChanged，修改多条时合并为一个 recordsChanged
+const (
+	recordChangedEvent  = "recordChanged"
+	recordsChangedEvent = "recordsChanged"
+)
+
+type ChangeEvent struct {
+	RecordType string        `json:"recordType"`
+	ID         string        `json:"id"`
+	TxId       string        `json:"txId"`
+	Operation  string        `json:"operation"` // 调用的方法名，如 add、update、patch
+	Changes    []FieldChange `json:"changes"`   // 新增时 old 为 null
+}
+
+type BatchChangeEvent struct {
+	TxId      string        `json:"txId"`
+	Operation string        `json:"operation"`
+	Events    []ChangeEvent `json:"events"` // 按首次修改的顺序排列
+}
+
+// 交易内同一记录可能被多次写入，保留交易前的旧值和最后写入的新值
+type recordChange struct {
+	recordType string
+	id         string
+	old        map[string]interface{}
+	new        map[string]interface{}
+}
+
+type changeLog struct {
+	changes []*recordChange
+}
+
+// 记录一次写入，old 和 new 为写入前后的 json，记录不存在时为 nil；不经过 Invoke 调用时（如单元测试）不记录
+func addRecordChange(stub shim.ChaincodeStubInterface, recordType string, id string, old []byte, new []byte) error {
+	ts, ok := stub.(*txStub)
+	if !ok {
+		return nil
+	}
+	newMap, err := jsonToMap(new)
+	if err != nil {
+		return err
+	}
+	for _, change := range ts.changeLog.changes {
+		if change.recordType == recordType && change.id == id {
+			change.new = newMap
+			return nil
+		}
+	}
+	oldMap, err := jsonToMap(old)
+	if err != nil {
+		return err
+	}
+	ts.changeLog.changes = append(ts.changeLog.changes, &recordChange{recordType: recordType, id: id, old: oldMap, new: newMap})
+	return nil
+}
+
+// 交易成功后调用，没有字段变化的记录不发送
+func (log *changeLog) emit(stub shim.ChaincodeStubInterface, operation string) error {
+	events := make([]ChangeEvent, 0, len(log.changes))
+	for _, change := range log.changes {
+		fieldChanges := diffMaps(change.old, change.new)
+		if len(fieldChanges) == 0 {
+			continue
+		}
+		events = append(events, ChangeEvent{
+			RecordType: change.recordType,
+			ID:         change.id,
+			TxId:       stub.GetTxID(),
+			Operation:  operation,
+			Changes:    fieldChanges,
+		})
+	}
+	var name string
+	var payload interface{}
+	switch len(events) {
+	case 0:
+		return nil
+	case 1:
+		name, payload = recordChangedEvent, &events[0]
+	default:
+		name, payload = recordsChangedEvent, &BatchChangeEvent{TxId: stub.GetTxID(), Operation: operation, Events: events}
+	}
+	jsonVal, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %s", err.Error())
+	}
+	return stub.SetEvent(name, jsonVal)
+}
+
+// 按 json 字段名返回有变化的字段
+func diffMaps(oldMap, newMap map[string]interface{}) []FieldChange {
+	fields := make([]string, 0, len(oldMap)+len(newMap))
+	for field := range oldMap {
+		fields = append(fields, field)
+	}
+	for field := range newMap {
+		if _, ok := oldMap[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	changes := make([]FieldChange, 0)
+	for _, field := range fields {
+		oldVal, newVal := oldMap[field], newMap[field]
+		if !reflect.DeepEqual(oldVal, newVal) {
+			changes = append(changes, FieldChange{Field: field, Old: oldVal, New: newVal})
+		}
+	}
+	return changes
+}
+
+func jsonToMap(jsonVal []byte) (map[string]interface{}, error) {
+	jsonMap := make(map[string]interface{})
+	if jsonVal == nil {
+		return jsonMap, nil
+	}
+	err := json.Unmarshal(jsonVal, &jsonMap)
+	if err != nil {
+		return nil, err
+	}
+	return jsonMap, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// 取出 MockStub 中最近一次交易的事件，没有时返回 nil
+func nextEvent(mockStub *shim.MockStub) *peer.ChaincodeEvent {
+	select {
+	case event := <-mockStub.ChaincodeEventsChannel:
+		return event
+	default:
+		return nil
+	}
+}
+
+func TestChangeEvents(t *testing.T) {
+	mockStub := shim.NewMockStub("asset", new(AssetFactory))
+	if _, err := invoke(mockStub, "tx1", "add", "a1", `{"id":"a1","remark":"old"}`); err != nil {
+		t.Fatal(err)
+	}
+	event := nextEvent(mockStub)
+	if event == nil || event.EventName != recordChangedEvent {
+		t.Fatal(event)
+	}
+
+	if _, err := invoke(mockStub, "tx2", "patch", "a1", `{"remark":"new"}`); err != nil {
+		t.Fatal(err)
+	}
+	event = nextEvent(mockStub)
+	change := ChangeEvent{}
+	_ = json.Unmarshal(event.Payload, &change)
+	if change.RecordType != "asset" || change.ID != "a1" || change.TxId != "tx2" || change.Operation != "patch" || len(change.Changes) != 2 {
+		t.Fatal(string(event.Payload))
+	}
+	if remark := change.Changes[0]; remark.Field != "remark" || remark.Old != "old" || remark.New != "new" {
+		t.Fatal(remark)
+	}
+
+	if _, err := invoke(mockStub, "tx3", "patch", "a1", `{"unknown":"x"}`); err == nil {
+		t.Fatal("unknown field should be rejected")
+	}
+	if event = nextEvent(mockStub); event != nil {
+		t.Fatal("failed transaction should not emit event")
+	}
+	if _, err := invoke(mockStub, "tx4", "getById", "a1"); err != nil {
+		t.Fatal(err)
+	}
+	if event = nextEvent(mockStub); event != nil {
+		t.Fatal("query should not emit event")
+	}
+
+	if _, err := invoke(mockStub, "tx5", "batchAdd", `[{"id":"a2"},{"id":"a3"}]`); err != nil {
+		t.Fatal(err)
+	}
+	event = nextEvent(mockStub)
+	batch := BatchChangeEvent{}
+	_ = json.Unmarshal(event.Payload, &batch)
+	if event.EventName != recordsChangedEvent || batch.Operation != "batchAdd" || len(batch.Events) != 2 || batch.Events[1].ID != "a3" {
+		t.Fatal(string(event.Payload))
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// 地球平均半径，单位米
+const earthRadius = 6371008.8
+
+type GeoPoint struct {
+	Lng float64 `json:"lng"` // 经度
+	Lat float64 `json:"lat"` // 纬度
+}
+
+type BoxQuery struct {
+	Pagination
+	MinLongitude float64 `json:"minLongitude"`
+	MinLatitude  float64 `json:"minLatitude"`
+	MaxLongitude float64 `json:"maxLongitude"`
+	MaxLatitude  float64 `json:"maxLatitude"`
+}
+
+type NearbyQuery struct {
+	Pagination
+	Longitude float64 `json:"longitude"`
+	Latitude  float64 `json:"latitude"`
+	Radius    float64 `json:"radius"` // 半径，单位米
+}
+
+type NearbyAsset struct {
+	Distance float64 `json:"distance"` // 与中心点的距离，单位米
+	Value    Asset   `json:"value"`
+}
+
+// 校验经纬度并生成 geo 字段，经纬度需同时为空或同时填写
+func normalizeGeo(asset *Asset) error {
+	asset.Geo = nil
+	if asset.Longitude == "" && asset.Latitude == "" {
+		return nil
+	}
+	if asset.Longitude == "" || asset.Latitude == "" {
+		return fmt.Errorf("longitude and latitude should be set together")
+	}
+	lng, err := strconv.ParseFloat(asset.Longitude, 64)
+	if err != nil || math.IsNaN(lng) || lng < -180 || lng > 180 {
+		return fmt.Errorf("longitude should be a number between -180 and 180, get %s", asset.Longitude)
+	}
+	lat, err := strconv.ParseFloat(asset.Latitude, 64)
+	if err != nil || math.IsNaN(lat) || lat < -90 || lat > 90 {
+		return fmt.Errorf("latitude should be a number between -90 and 90, get %s", asset.Latitude)
+	}
+	asset.Geo = &GeoPoint{Lng: lng, Lat: lat}
+	return nil
+}
+
+// 分页查询矩形范围内的资产，边界包含在内
+// minLongitude float required
+// minLatitude float required
+// maxLongitude float required
+// maxLatitude float required
+// bookmark string
+// pageSize int required
+// res : {data:[Asset],"bookmark": "bookmark"}
+func queryAssetsInBox(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("should have 1 args")
+	}
+	argStruct := BoxQuery{}
+	err := json.Unmarshal([]byte(args[0]), &argStruct)
+	if err != nil {
+		return shim.Error("failed to unmarshal argStruct:" + err.Error())
+	}
+	if argStruct.PageSize <= 0 {
+		return shim.Error("pageSize should be greater than 0")
+	}
+	if argStruct.MinLongitude > argStruct.MaxLongitude || argStruct.MinLatitude > argStruct.MaxLatitude {
+		return shim.Error("min longitude/latitude should not be greater than max longitude/latitude")
+	}
+
+	query, err := buildBoxQuery(argStruct.MinLongitude, argStruct.MinLatitude, argStruct.MaxLongitude, argStruct.MaxLatitude)
+	if err != nil {
+		return shim.Error("failed to generate query string:" + err.Error())
+	}
+
+	resultsIterator, responseMetadata, err := stub.GetQueryResultWithPagination(query, argStruct.PageSize, argStruct.Bookmark)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	buf, err := constructQueryResponseFromIterator(resultsIterator, responseMetadata.Bookmark)
+	if err != nil {
+		return shim.Error("failed to generate res" + err.Error())
+	}
+	return shim.Success(buf.Bytes())
+}
+
+// 分页查询距离中心点 radius 米以内的资产，先按外接矩形查询再按球面距离过滤，
+// 因此每页返回的数量可能少于 pageSize，页内按距离升序排列
+// longitude float required
+// latitude float required
+// radius float required
+// bookmark string
+// pageSize int required
+// res : {data:[{"distance": 0, "value": Asset}],"bookmark": "bookmark"}
+func queryAssetsNearby(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("should have 1 args")
+	}
+	argStruct := NearbyQuery{}
+	err := json.Unmarshal([]byte(args[0]), &argStruct)
+	if err != nil {
+		return shim.Error("failed to unmarshal argStruct:" + err.Error())
+	}
+	if argStruct.PageSize <= 0 {
+		return shim.Error("pageSize should be greater than 0")
+	}
+	if argStruct.Radius <= 0 {
+		return shim.Error("radius should be greater than 0")
+	}
+	center := GeoPoint{Lng: argStruct.Longitude, Lat: argStruct.Latitude}
+	if center.Lng < -180 || center.Lng > 180 || center.Lat < -90 || center.Lat > 90 {
+		return shim.Error("longitude or latitude out of range")
+	}
+
+	minLng, minLat, maxLng, maxLat := boundingBox(center, argStruct.Radius)
+	query, err := buildBoxQuery(minLng, minLat, maxLng, maxLat)
+	if err != nil {
+		return shim.Error("failed to generate query string:" + err.Error())
+	}
+
+	resultsIterator, responseMetadata, err := stub.GetQueryResultWithPagination(query, argStruct.PageSize, argStruct.Bookmark)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	data := make([]NearbyAsset, 0)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error("failed get resultsIterator:" + err.Error())
+		}
+		asset := Asset{}
+		err = json.Unmarshal(queryResponse.Value, &asset)
+		if err != nil {
+			return shim.Error("failed to unmarshal asset:" + err.Error())
+		}
+		if asset.Geo == nil {
+			continue
+		}
+		distance := haversine(center, *asset.Geo)
+		if distance <= argStruct.Radius {
+			data = append(data, NearbyAsset{Distance: distance, Value: asset})
+		}
+	}
+	sort.SliceStable(data, func(i, j int) bool {
+		return data[i].Distance < data[j].Distance
+	})
+
+	res := map[string]interface{}{
+		"data":     data,
+		"bookmark": responseMetadata.Bookmark,
+	}
+	resStr, err := json.Marshal(&res)
+	if err != nil {
+		return shim.Error("failed to marshal res" + err.Error())
+	}
+	return shim.Success(resStr)
+}
+
+func buildBoxQuery(minLng, minLat, maxLng, maxLat float64) (string, error) {
+	queryMap := map[string]interface{}{
+		"selector": map[string]interface{}{
+			"geo.lng": map[string]float64{
+				"$gte": minLng,
+				"$lte": maxLng,
+			},
+			"geo.lat": map[string]float64{
+				"$gte": minLat,
+				"$lte": maxLat,
+			},
+		},
+		"use_index": []string{"_design/assetGeoDoc", "assetGeo"},
+	}
+	query, err := json.Marshal(&queryMap)
+	if err != nil {
+		return "", err
+	}
+	return string(query), nil
+}
+
+// 计算圆的外接矩形，靠近极点或跨越 180 度经线时经度取全部范围
+func boundingBox(center GeoPoint, radius float64) (minLng, minLat, maxLng, maxLat float64) {
+	deltaLat := radius / earthRadius * 180 / math.Pi
+	minLat = math.Max(center.Lat-deltaLat, -90)
+	maxLat = math.Min(center.Lat+deltaLat, 90)
+
+	cosLat := math.Cos(math.Max(math.Abs(minLat), math.Abs(maxLat)) * math.Pi / 180)
+	if cosLat <= 0 || minLat == -90 || maxLat == 90 {
+		return -180, minLat, 180, maxLat
+	}
+	deltaLng := deltaLat / cosLat
+	minLng, maxLng = center.Lng-deltaLng, center.Lng+deltaLng
+	if minLng < -180 || maxLng > 180 {
+		return -180, minLat, 180, maxLat
+	}
+	return minLng, minLat, maxLng, maxLat
+}
+
+// 球面距离，单位米
+func haversine(a, b GeoPoint) float64 {
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	deltaLat := lat2 - lat1
+	deltaLng := (b.Lng - a.Lng) * math.Pi / 180
+	h := math.Sin(deltaLat/2)*math.Sin(deltaLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(deltaLng/2)*math.Sin(deltaLng/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
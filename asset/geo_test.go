@@ -0,0 +1,47 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNormalizeGeo(t *testing.T) {
+	asset := Asset{Longitude: "116.397", Latitude: "39.908"}
+	if err := normalizeGeo(&asset); err != nil {
+		t.Fatal(err)
+	}
+	if asset.Geo == nil || asset.Geo.Lng != 116.397 || asset.Geo.Lat != 39.908 {
+		t.Fatal(asset.Geo)
+	}
+
+	invalid := []Asset{
+		{Longitude: "116.397"},
+		{Longitude: "190", Latitude: "39.908"},
+		{Longitude: "116.397", Latitude: "north"},
+	}
+	for _, asset := range invalid {
+		if err := normalizeGeo(&asset); err == nil {
+			t.Fatal(asset)
+		}
+	}
+}
+
+func TestHaversine(t *testing.T) {
+	beijing := GeoPoint{Lng: 116.4074, Lat: 39.9042}
+	shanghai := GeoPoint{Lng: 121.4737, Lat: 31.2304}
+	distance := haversine(beijing, shanghai)
+	if math.Abs(distance-1067000) > 5000 {
+		t.Fatal(distance)
+	}
+}
+
+func TestBoundingBox(t *testing.T) {
+	center := GeoPoint{Lng: 116.4074, Lat: 39.9042}
+	minLng, minLat, maxLng, maxLat := boundingBox(center, 1000)
+	corners := []GeoPoint{{Lng: minLng, Lat: center.Lat}, {Lng: maxLng, Lat: center.Lat}, {Lng: center.Lng, Lat: minLat}, {Lng: center.Lng, Lat: maxLat}}
+	for _, corner := range corners {
+		if haversine(center, corner) < 999 {
+			t.Fatal(corner)
+		}
+	}
+}
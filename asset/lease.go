@@ -0,0 +1,527 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	leaseObjectType = "lease"
+
+	LeaseStatusActive     = "active"
+	LeaseStatusTerminated = "terminated"
+)
+
+type Lease struct {
+	ID            string `json:"id"`                      // 租约编号
+	AssetID       string `json:"assetId"`                 // 资产id
+	Tenant        string `json:"tenant"`                  // 承租人
+	LeasedArea    string `json:"leasedArea"`              // 租赁面积
+	StartDate     string `json:"startDate"`               // 起租日期 yyyy-MM-dd
+	EndDate       string `json:"endDate"`                 // 到期日期 yyyy-MM-dd
+	Rent          string `json:"rent"`                    // 租金
+	Status        string `json:"status"`                  // active 生效中，terminated 已终止
+	CreateTxId    string `json:"createTxId"`              // 登记交易
+	CreateTime    string `json:"createTime"`              // 登记时间
+	TerminateTxId string `json:"terminateTxId,omitempty"` // 终止交易
+	TerminateTime string `json:"terminateTime,omitempty"` // 终止时间
+	Version       int64  `json:"version"`                 // 版本号，由链码维护，每次修改加 1
+}
+
+type Occupancy struct {
+	AssetID       string `json:"assetId,omitempty"`
+	Location      string `json:"location,omitempty"`
+	Date          string `json:"date"`          // 统计日期，取交易时间
+	AssetCount    int    `json:"assetCount"`    // 资产数量
+	RentableArea  string `json:"rentableArea"`  // 可出租面积
+	LeasedArea    string `json:"leasedArea"`    // 已出租面积
+	VacantArea    string `json:"vacantArea"`    // 空置面积
+	OccupancyRate string `json:"occupancyRate"` // 出租率，保留 4 位小数
+}
+
+// 登记租约，需由资产所有者调用，租期内任一日期生效租约同时占用的面积不能超过资产的可出租面积
+// id string required
+// assetId string required
+// tenant string required
+// leasedArea string required
+// startDate string required yyyy-MM-dd
+// endDate string required yyyy-MM-dd
+// rent string required 最多两位小数
+func addLease(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("should have 1 args")
+	}
+	lease := Lease{}
+	decoder := json.NewDecoder(strings.NewReader(args[0]))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&lease)
+	if err != nil {
+		return shim.Error("invalid lease: " + err.Error())
+	}
+	err = validateLease(&lease)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	asset, err := getAsset(stub, lease.AssetID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if asset == nil {
+		return shim.Error("asset " + lease.AssetID + " " + ErrorNotFound)
+	}
+	err = checkOwner(stub, asset)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	rentableArea, err := parseAreaUnits("rentableArea", asset.RentableArea)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	leases, err := getLeases(stub, lease.AssetID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for _, other := range leases {
+		if other.ID == lease.ID {
+			return shim.Error("lease " + lease.ID + " already exists")
+		}
+	}
+	lease.Status = LeaseStatusActive
+	peakArea, peakDate, err := peakLeasedArea(append(leases, lease), lease.StartDate, lease.EndDate)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if peakArea > rentableArea {
+		return shim.Error(fmt.Sprintf("leased area %s on %s exceeds rentable area %s", formatAreaUnits(peakArea), peakDate, asset.RentableArea))
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	lease.CreateTxId = stub.GetTxID()
+	lease.CreateTime = txTime.Format(time.RFC3339)
+	lease.TerminateTxId = ""
+	lease.TerminateTime = ""
+	err = putLease(stub, &lease)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(stub.GetTxID()))
+}
+
+// 终止租约，需由资产所有者调用，终止后不再占用可出租面积
+// assetId string required
+// leaseId string required
+// expectedVersion string 可选，租约的当前版本，不一致时报错
+func terminateLease(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("should have 2 or 3 args")
+	}
+	assetId, leaseId := args[0], args[1]
+	expectedVersion := ""
+	if len(args) == 3 {
+		expectedVersion = args[2]
+	}
+	lease, err := getLease(stub, assetId, leaseId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if lease == nil {
+		return shim.Error("lease " + leaseId + " " + ErrorNotFound)
+	}
+	err = checkVersion(leaseId, lease.Version, expectedVersion)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if lease.Status != LeaseStatusActive {
+		return shim.Error("lease " + leaseId + " is already " + lease.Status)
+	}
+	asset, err := getAsset(stub, assetId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if asset == nil {
+		return shim.Error("asset " + assetId + " " + ErrorNotFound)
+	}
+	err = checkOwner(stub, asset)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	lease.Status = LeaseStatusTerminated
+	lease.TerminateTxId = stub.GetTxID()
+	lease.TerminateTime = txTime.Format(time.RFC3339)
+	err = putLease(stub, lease)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(stub.GetTxID()))
+}
+
+// 查询资产的租约
+// assetId string required
+// status string 可选 active 或 terminated，为空时返回全部
+// res : [Lease]
+func getLeasesByAssetId(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("should have 1 or 2 args")
+	}
+	assetId := args[0]
+	status := ""
+	if len(args) == 2 {
+		status = args[1]
+	}
+	if assetId == "" {
+		return shim.Error("assetId is required")
+	}
+	if status != "" && status != LeaseStatusActive && status != LeaseStatusTerminated {
+		return shim.Error("status should be active or terminated, get " + status)
+	}
+	leases, err := getLeases(stub, assetId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	data := make([]Lease, 0, len(leases))
+	for _, lease := range leases {
+		if status == "" || lease.Status == status {
+			data = append(data, lease)
+		}
+	}
+	res, err := json.Marshal(&data)
+	if err != nil {
+		return shim.Error("failed to marshal leases:" + err.Error())
+	}
+	return shim.Success(res)
+}
+
+// 查询资产当前的出租和空置面积，当前日期取交易时间
+// assetId string required
+// res : Occupancy
+func getOccupancyByAssetId(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("should have 1 args")
+	}
+	assetId := args[0]
+	asset, err := getAsset(stub, assetId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if asset == nil {
+		return shim.Error(ErrorNotFound)
+	}
+	today, err := getTxDate(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	rentableArea, leasedArea, err := assetOccupancy(stub, asset, today)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	occupancy := newOccupancy(today, 1, rentableArea, leasedArea)
+	occupancy.AssetID = assetId
+	res, err := json.Marshal(&occupancy)
+	if err != nil {
+		return shim.Error("failed to marshal occupancy:" + err.Error())
+	}
+	return shim.Success(res)
+}
+
+// 汇总某个位置下所有资产当前的出租和空置面积（依赖 couchdb）
+// location string required
+// res : Occupancy
+func getOccupancyByLocation(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("should have 1 args")
+	}
+	location := args[0]
+	if location == "" {
+		return shim.Error("location is required")
+	}
+	today, err := getTxDate(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	query, err := buildAssetQuery(&AssetQuery{Location: location})
+	if err != nil {
+		return shim.Error("failed to generate query string:" + err.Error())
+	}
+	resultsIterator, err := stub.GetQueryResult(query)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	assetCount := 0
+	var totalRentable, totalLeased int64
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error("failed get resultsIterator:" + err.Error())
+		}
+		asset := Asset{}
+		err = json.Unmarshal(queryResponse.Value, &asset)
+		if err != nil {
+			return shim.Error("failed to unmarshal asset:" + err.Error())
+		}
+		rentableArea, leasedArea, err := assetOccupancy(stub, &asset, today)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		assetCount++
+		totalRentable += rentableArea
+		totalLeased += leasedArea
+	}
+	occupancy := newOccupancy(today, assetCount, totalRentable, totalLeased)
+	occupancy.Location = location
+	res, err := json.Marshal(&occupancy)
+	if err != nil {
+		return shim.Error("failed to marshal occupancy:" + err.Error())
+	}
+	return shim.Success(res)
+}
+
+// 修改可出租面积时，不能小于交易日及以后任一时间点生效租约占用的面积；已到期的租约不再占用面积
+func checkRentableArea(stub shim.ChaincodeStubInterface, asset *Asset) error {
+	leases, err := getLeases(stub, asset.ID)
+	if err != nil {
+		return err
+	}
+	today, err := getTxDate(stub)
+	if err != nil {
+		return err
+	}
+	peakArea, peakDate, err := peakLeasedArea(leases, today, "9999-12-31")
+	if err != nil {
+		return err
+	}
+	if peakArea == 0 {
+		return nil
+	}
+	rentableArea, err := parseAreaUnits("rentableArea", asset.RentableArea)
+	if err != nil {
+		return err
+	}
+	if peakArea > rentableArea {
+		return fmt.Errorf("rentableArea %s is less than leased area %s on %s", asset.RentableArea, formatAreaUnits(peakArea), peakDate)
+	}
+	return nil
+}
+
+// 计算 [from, to] 期间生效租约同时占用面积的最大值及对应日期，
+// 占用面积只在起租日增加，因此只需检查 from 和期间内的各个起租日；面积与 areaTotals 一样以 0.0001 平方米为单位
+func peakLeasedArea(leases []Lease, from string, to string) (int64, string, error) {
+	dates := []string{from}
+	for _, lease := range leases {
+		if lease.Status == LeaseStatusActive && lease.StartDate > from && lease.StartDate <= to {
+			dates = append(dates, lease.StartDate)
+		}
+	}
+	var peakArea int64
+	peakDate := from
+	for _, date := range dates {
+		var leasedArea int64
+		for _, lease := range leases {
+			if lease.Status != LeaseStatusActive || lease.StartDate > date || lease.EndDate < date {
+				continue
+			}
+			area, err := parseAreaUnits("leasedArea", lease.LeasedArea)
+			if err != nil {
+				return 0, "", err
+			}
+			leasedArea += area
+		}
+		if leasedArea > peakArea {
+			peakArea, peakDate = leasedArea, date
+		}
+	}
+	return peakArea, peakDate, nil
+}
+
+// 返回资产的可出租面积和 date 当天生效租约占用的面积
+func assetOccupancy(stub shim.ChaincodeStubInterface, asset *Asset, date string) (int64, int64, error) {
+	var rentableArea int64
+	if asset.RentableArea != "" {
+		area, err := parseAreaUnits("rentableArea", asset.RentableArea)
+		if err != nil {
+			return 0, 0, fmt.Errorf("asset %s: %s", asset.ID, err.Error())
+		}
+		rentableArea = area
+	}
+	leases, err := getLeases(stub, asset.ID)
+	if err != nil {
+		return 0, 0, err
+	}
+	leasedArea, _, err := peakLeasedArea(leases, date, date)
+	if err != nil {
+		return 0, 0, err
+	}
+	return rentableArea, leasedArea, nil
+}
+
+func newOccupancy(date string, assetCount int, rentableArea int64, leasedArea int64) Occupancy {
+	rate := 0.0
+	if rentableArea > 0 {
+		rate = math.Round(float64(leasedArea)/float64(rentableArea)*10000) / 10000
+	}
+	vacantArea := rentableArea - leasedArea
+	if vacantArea < 0 {
+		vacantArea = 0
+	}
+	return Occupancy{
+		Date:          date,
+		AssetCount:    assetCount,
+		RentableArea:  formatAreaUnits(rentableArea),
+		LeasedArea:    formatAreaUnits(leasedArea),
+		VacantArea:    formatAreaUnits(vacantArea),
+		OccupancyRate: strconv.FormatFloat(rate, 'f', 4, 64),
+	}
+}
+
+func validateLease(lease *Lease) error {
+	if lease.ID == "" || lease.AssetID == "" || lease.Tenant == "" {
+		return fmt.Errorf("id, assetId and tenant is required")
+	}
+	leasedArea, err := parseAreaUnits("leasedArea", lease.LeasedArea)
+	if err != nil {
+		return err
+	}
+	if leasedArea <= 0 {
+		return fmt.Errorf("leasedArea should be greater than 0")
+	}
+	if !amountPattern.MatchString(lease.Rent) {
+		return fmt.Errorf("rent should be a number with at most 2 decimals, get %s", lease.Rent)
+	}
+	start, err := time.Parse(dateLayout, lease.StartDate)
+	if err != nil {
+		return fmt.Errorf("startDate should be yyyy-MM-dd, get %s", lease.StartDate)
+	}
+	end, err := time.Parse(dateLayout, lease.EndDate)
+	if err != nil {
+		return fmt.Errorf("endDate should be yyyy-MM-dd, get %s", lease.EndDate)
+	}
+	if end.Before(start) {
+		return fmt.Errorf("endDate should not be before startDate")
+	}
+	return nil
+}
+
+// 面积的十进制表示，汇总值可能为负数（增量维护出现偏差时），整数部分不超过 int64 能容纳的位数
+var areaPattern = regexp.MustCompile(`^(-?)(\d{1,14})(?:\.(\d+))?$`)
+
+// 直接按十进制字符串换算为 0.0001 平方米为单位的整数，超出 4 位的小数四舍五入
+func decimalAreaUnits(value string) (int64, bool) {
+	match := areaPattern.FindStringSubmatch(value)
+	if match == nil {
+		return 0, false
+	}
+	fraction := match[3] + "00000"
+	units, err := strconv.ParseInt(match[2]+fraction[:4], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	if fraction[4] >= '5' {
+		units++
+	}
+	if match[1] == "-" {
+		units = -units
+	}
+	return units, true
+}
+
+// 换算为 0.0001 平方米为单位的整数，与 areaTotals 相同，累加和比较不受浮点误差影响
+func parseAreaUnits(name string, value string) (int64, error) {
+	units, ok := decimalAreaUnits(value)
+	if !ok || units < 0 {
+		return 0, fmt.Errorf("%s should be a non-negative number, get %s", name, value)
+	}
+	if units > maxStatsArea*10000 {
+		return 0, fmt.Errorf("%s should not exceed %.0f, get %s", name, maxStatsArea, value)
+	}
+	return units, nil
+}
+
+func getTxDate(stub shim.ChaincodeStubInterface) (string, error) {
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return "", err
+	}
+	return txTime.Format(dateLayout), nil
+}
+
+func getLease(stub shim.ChaincodeStubInterface, assetId string, leaseId string) (*Lease, error) {
+	key, err := stub.CreateCompositeKey(leaseObjectType, []string{assetId, leaseId})
+	if err != nil {
+		return nil, err
+	}
+	jsonVal, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if jsonVal == nil {
+		return nil, nil
+	}
+	lease := Lease{}
+	err = json.Unmarshal(jsonVal, &lease)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal lease %s: %s", leaseId, err.Error())
+	}
+	return &lease, nil
+}
+
+func getLeases(stub shim.ChaincodeStubInterface, assetId string) ([]Lease, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(leaseObjectType, []string{assetId})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	leases := make([]Lease, 0)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		lease := Lease{}
+		err = json.Unmarshal(queryResponse.Value, &lease)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal lease: %s", err.Error())
+		}
+		leases = append(leases, lease)
+	}
+	return leases, nil
+}
+
+// 写入租约，版本号在已有记录的基础上加 1
+func putLease(stub shim.ChaincodeStubInterface, lease *Lease) error {
+	previous, err := getLease(stub, lease.AssetID, lease.ID)
+	if err != nil {
+		return err
+	}
+	lease.Version = 1
+	if previous != nil {
+		lease.Version = previous.Version + 1
+	}
+	key, err := stub.CreateCompositeKey(leaseObjectType, []string{lease.AssetID, lease.ID})
+	if err != nil {
+		return err
+	}
+	jsonVal, err := json.Marshal(lease)
+	if err != nil {
+		return fmt.Errorf("failed to marshal lease: %s", err.Error())
+	}
+	return stub.PutState(key, jsonVal)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func leaseJson(id string, area string, start string, end string) string {
+	return `{"id":"` + id + `","assetId":"a1","tenant":"tenant","leasedArea":"` + area + `","startDate":"` + start + `","endDate":"` + end + `","rent":"1000"}`
+}
+
+func TestLeaseArea(t *testing.T) {
+	mockStub := shim.NewMockStub("asset", new(AssetFactory))
+	if _, err := invoke(mockStub, "tx1", "add", "a1", `{"id":"a1","rentableArea":"100"}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invoke(mockStub, "tx2", "addLease", leaseJson("l1", "60", "2000-01-01", "2000-06-30")); err != nil {
+		t.Fatal(err)
+	}
+	// 与 l1 不重叠，可以再租出 60
+	if _, err := invoke(mockStub, "tx3", "addLease", leaseJson("l2", "60", "2000-07-01", "2099-12-31")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invoke(mockStub, "tx4", "addLease", leaseJson("l3", "50", "2000-06-01", "2000-12-31")); err == nil {
+		t.Fatal("leased area exceeding rentable area should be rejected")
+	}
+	if _, err := invoke(mockStub, "tx5", "patch", "a1", `{"rentableArea":"50"}`); err == nil {
+		t.Fatal("rentableArea less than leased area should be rejected")
+	}
+
+	payload, err := invoke(mockStub, "tx6", "getOccupancyByAssetId", "a1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	occupancy := Occupancy{}
+	if err = json.Unmarshal(payload, &occupancy); err != nil {
+		t.Fatal(err)
+	}
+	if occupancy.LeasedArea != "60" || occupancy.VacantArea != "40" || occupancy.OccupancyRate != "0.6000" {
+		t.Fatal(occupancy)
+	}
+
+	if _, err := invoke(mockStub, "tx7", "terminateLease", "a1", "l2", "2"); err == nil {
+		t.Fatal("stale lease version should be rejected")
+	}
+	if _, err := invoke(mockStub, "tx7", "terminateLease", "a1", "l2", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invoke(mockStub, "tx8", "addLease", leaseJson("l3", "50", "2000-06-01", "2000-12-31")); err == nil {
+		t.Fatal("overlap with l1 should still be rejected")
+	}
+	if _, err := invoke(mockStub, "tx9", "addLease", leaseJson("l3", "40", "2000-06-01", "2000-12-31")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLeaseAreaFixedPoint(t *testing.T) {
+	mockStub := shim.NewMockStub("asset", new(AssetFactory))
+	if _, err := invoke(mockStub, "tx1", "add", "a1", `{"id":"a1","rentableArea":"0.3"}`); err != nil {
+		t.Fatal(err)
+	}
+	// 浮点数 0.1+0.1+0.1 大于 0.3，按 0.0001 平方米累加时正好租满
+	for _, id := range []string{"l1", "l2", "l3"} {
+		if _, err := invoke(mockStub, "tx-"+id, "addLease", leaseJson(id, "0.1", "2000-01-01", "2099-12-31")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := invoke(mockStub, "tx2", "addLease", leaseJson("l4", "0.0001", "2000-01-01", "2099-12-31")); err == nil {
+		t.Fatal("leased area exceeding rentable area should be rejected")
+	}
+}
+
+func TestRentableAreaAfterLeaseExpired(t *testing.T) {
+	mockStub := shim.NewMockStub("asset", new(AssetFactory))
+	if _, err := invoke(mockStub, "tx1", "add", "a1", `{"id":"a1","rentableArea":"100"}`); err != nil {
+		t.Fatal(err)
+	}
+	// l1 已到期但状态仍为 active，不应限制可出租面积；l2 仍在租期内
+	if _, err := invoke(mockStub, "tx2", "addLease", leaseJson("l1", "90", "2000-01-01", "2000-12-31")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invoke(mockStub, "tx3", "addLease", leaseJson("l2", "30", "2001-01-01", "2099-12-31")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invoke(mockStub, "tx4", "patch", "a1", `{"rentableArea":"20"}`); err == nil {
+		t.Fatal("rentableArea less than l2 should be rejected")
+	}
+	if _, err := invoke(mockStub, "tx5", "patch", "a1", `{"rentableArea":"30"}`); err != nil {
+		t.Fatal(err)
+	}
+	asset, _ := getAsset(mockStub, "a1")
+	if asset.RentableArea != "30" {
+		t.Fatal(asset)
+	}
+}
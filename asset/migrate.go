@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	// 组合键的前缀，与 shim 中的定义一致
+	compositeKeyNamespace = "\x00"
+	// 范围查询的上界，MockStub 在指定 startKey 且 endKey 为空时不返回结果，因此用最大的 unicode 字符代替空值
+	maxSimpleKey = "\U0010FFFF"
+)
+
+type MigrateResult struct {
+	Migrated int              `json:"migrated"` // 本次迁移的数量
+	Failed   []MigrateFailure `json:"failed"`   // 本次跳过的记录，仍保留在原 key 下
+	NextKey  string           `json:"nextKey"`  // 下一批的起始 key，为空表示已扫描完
+}
+
+type MigrateFailure struct {
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+}
+
+// 将升级前以 id 为 key 的资产迁移到组合键 asset[id]，需由 admin 属性为 true 的身份调用
+// 资产的版本保持不变，同时补上 assetNo 索引和面积汇总；内容无法解析、id 不一致、组合键下已存在
+// 或 assetNo 已被其他资产使用的记录会被跳过并在 failed 中返回，不影响其他记录的迁移
+// 资产较多时可分批调用，将返回的 nextKey 作为下一次的 startKey，直到 nextKey 为空
+// limit string 可选，本次最多处理的数量，包括跳过的记录
+// startKey string 可选，本次扫描的起始 key
+// res : MigrateResult
+func migrateKeys(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) > 2 {
+		return shim.Error("should have 0 to 2 args")
+	}
+	limit := 0
+	if len(args) >= 1 && args[0] != "" {
+		var err error
+		limit, err = strconv.Atoi(args[0])
+		if err != nil || limit <= 0 {
+			return shim.Error("limit should be a positive integer, get " + args[0])
+		}
+	}
+	startKey := ""
+	if len(args) == 2 {
+		startKey = args[1]
+	}
+	err := checkAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// fabric 的范围查询不会返回组合键，这里的判断用于兼容 MockStub
+	resultsIterator, err := stub.GetStateByRange(startKey, maxSimpleKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	result := MigrateResult{Failed: make([]MigrateFailure, 0)}
+	// 索引的范围查询读不到本交易写入的 assetNo，批次内的重复需要单独检查
+	assetNos := make(map[string]string)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error("failed get resultsIterator:" + err.Error())
+		}
+		if strings.HasPrefix(queryResponse.Key, compositeKeyNamespace) {
+			continue
+		}
+		if limit > 0 && result.Migrated+len(result.Failed) >= limit {
+			result.NextKey = queryResponse.Key
+			break
+		}
+		reason, err := migrateAsset(stub, queryResponse.Key, queryResponse.Value, assetNos)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if reason != "" {
+			result.Failed = append(result.Failed, MigrateFailure{Key: queryResponse.Key, Reason: reason})
+			continue
+		}
+		result.Migrated++
+	}
+	res, err := json.Marshal(&result)
+	if err != nil {
+		return shim.Error("failed to marshal res" + err.Error())
+	}
+	return shim.Success(res)
+}
+
+// 迁移一条资产，记录不能迁移时返回原因，读写状态出错时返回 error
+func migrateAsset(stub shim.ChaincodeStubInterface, id string, jsonVal []byte, assetNos map[string]string) (string, error) {
+	asset := Asset{}
+	err := json.Unmarshal(jsonVal, &asset)
+	if err != nil {
+		return fmt.Sprintf("failed to unmarshal asset %s: %s", id, err.Error()), nil
+	}
+	if asset.ID != id {
+		return fmt.Sprintf("id in value should be %s, get %s", id, asset.ID), nil
+	}
+	key, err := assetKey(stub, id)
+	if err != nil {
+		return "", err
+	}
+	existing, err := stub.GetState(key)
+	if err != nil {
+		return "", err
+	}
+	if existing != nil {
+		return fmt.Sprintf("asset %s already exists under the namespaced key", id), nil
+	}
+	if asset.AssetNo != "" {
+		if other, ok := assetNos[asset.AssetNo]; ok {
+			return fmt.Sprintf("assetNo %s is already used by asset %s", asset.AssetNo, other), nil
+		}
+		indexedId, err := getIdByAssetNo(stub, asset.AssetNo)
+		if err != nil {
+			return "", err
+		}
+		if indexedId != "" && indexedId != id {
+			return fmt.Sprintf("assetNo %s is already used by asset %s", asset.AssetNo, indexedId), nil
+		}
+		assetNos[asset.AssetNo] = id
+	}
+
+	err = indexAssetNo(stub, &asset)
+	if err != nil {
+		return "", err
+	}
+	err = updateAreaStats(stub, nil, &asset)
+	if err != nil {
+		return "", err
+	}
+	err = stub.PutState(key, jsonVal)
+	if err != nil {
+		return "", err
+	}
+	return "", stub.DelState(id)
+}
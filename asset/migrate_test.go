@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestMigrateKeys(t *testing.T) {
+	mockStub := shim.NewMockStub("asset", new(AssetFactory))
+	if _, err := invoke(mockStub, "tx1", "add", "a1", `{"id":"a1","assetNo":"no1","location":"east","area":"10"}`); err != nil {
+		t.Fatal(err)
+	}
+	// 模拟升级前以 id 为 key 写入的资产，其中 a0 的 id 不一致，a3 的 assetNo 与 a1 重复，a5 不是 json
+	mockStub.MockTransactionStart("legacy")
+	_ = mockStub.PutState("a0", []byte(`{"id":"other"}`))
+	_ = mockStub.PutState("a2", []byte(`{"id":"a2","assetName":"legacy","assetNo":"no2","location":"east","area":"5","version":3}`))
+	_ = mockStub.PutState("a3", []byte(`{"id":"a3","assetNo":"no1"}`))
+	_ = mockStub.PutState("a4", []byte(`{"id":"a4","location":"east","area":"1.5"}`))
+	_ = mockStub.PutState("a5", []byte(`not json`))
+	mockStub.MockTransactionEnd("legacy")
+
+	if _, err := invoke(mockStub, "tx2", "migrateKeys"); err == nil {
+		t.Fatal("only admin can migrate keys")
+	}
+	if _, err := invoke(mockStub, "tx2", "migrateKeys", "0"); err == nil {
+		t.Fatal("limit should be positive")
+	}
+	admin := newCreator("Org1MSP", "admin", map[string]string{"admin": "true"})
+	migrate := func(txId string, args ...string) MigrateResult {
+		payload, err := invokeAs(mockStub, admin, txId, append([]string{"migrateKeys"}, args...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := MigrateResult{}
+		if err := json.Unmarshal(payload, &result); err != nil {
+			t.Fatal(err, string(payload))
+		}
+		return result
+	}
+
+	// 跳过的记录计入 limit，下一批从 nextKey 继续
+	result := migrate("tx3", "3")
+	if result.Migrated != 1 || len(result.Failed) != 2 || result.Failed[0].Key != "a0" || result.Failed[1].Key != "a3" || result.NextKey != "a4" {
+		t.Fatal(result)
+	}
+	result = migrate("tx4", "3", result.NextKey)
+	if result.Migrated != 1 || len(result.Failed) != 1 || result.Failed[0].Key != "a5" || result.NextKey != "" {
+		t.Fatal(result)
+	}
+	if value, _ := mockStub.GetState("a2"); value != nil {
+		t.Fatal("legacy key should be deleted")
+	}
+	if value, _ := mockStub.GetState("a3"); value == nil {
+		t.Fatal("failed record should be kept")
+	}
+
+	payload, err := invoke(mockStub, "tx5", "getByAssetNo", "no2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	asset := Asset{}
+	_ = json.Unmarshal(payload, &asset)
+	if asset.ID != "a2" || asset.AssetName != "legacy" || asset.Version != 3 {
+		t.Fatal(string(payload))
+	}
+	if east := areaStats(t, mockStub, "location", "east"); len(east) != 1 || east[0].Count != 3 || east[0].Area != "16.5" {
+		t.Fatal(east)
+	}
+
+	// 失败的记录仍在原 key 下，修复前再次迁移依旧跳过
+	if result = migrate("tx6"); result.Migrated != 0 || len(result.Failed) != 3 {
+		t.Fatal(result)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	mortgageObjectType = "mortgage"
+
+	MortgageStatusActive   = "active"
+	MortgageStatusReleased = "released"
+
+	// Asset.IsMortgage 的取值，由抵押记录推导
+	IsMortgageYes = "1"
+	IsMortgageNo  = "0"
+
+	dateLayout = "2006-01-02"
+)
+
+var (
+	amountPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]{1,2})?$`)
+	hashPattern   = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+type Mortgage struct {
+	ID           string `json:"id"`                    // 抵押编号
+	AssetID      string `json:"assetId"`               // 资产id
+	Lender       string `json:"lender"`                // 抵押权人
+	Amount       string `json:"amount"`                // 抵押金额
+	StartDate    string `json:"startDate"`             // 开始日期 yyyy-MM-dd
+	EndDate      string `json:"endDate"`               // 结束日期 yyyy-MM-dd
+	ContractHash string `json:"contractHash"`          // 抵押合同 sha256
+	Status       string `json:"status"`                // active 生效中，released 已解除
+	RegisterTxId string `json:"registerTxId"`          // 登记交易
+	RegisterTime string `json:"registerTime"`          // 登记时间
+	ReleaseTxId  string `json:"releaseTxId,omitempty"` // 解除交易
+	ReleaseTime  string `json:"releaseTime,omitempty"` // 解除时间
+	Version      int64  `json:"version"`               // 版本号，由链码维护，每次修改加 1
+}
+
+// 登记抵押，需由资产所有者调用，登记后资产的 isMortgage 置为 1
+// id string required
+// assetId string required
+// lender string required
+// amount string required 最多两位小数
+// startDate string required yyyy-MM-dd
+// endDate string required yyyy-MM-dd
+// contractHash string required 小写十六进制 sha256
+// expectedVersion string 可选，资产的当前版本，不一致时报错
+func registerMortgage(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("should have 1 or 2 args")
+	}
+	expectedVersion := ""
+	if len(args) == 2 {
+		expectedVersion = args[1]
+	}
+	mortgage := Mortgage{}
+	decoder := json.NewDecoder(strings.NewReader(args[0]))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&mortgage)
+	if err != nil {
+		return shim.Error("invalid mortgage: " + err.Error())
+	}
+	err = validateMortgage(&mortgage)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	asset, err := getAsset(stub, mortgage.AssetID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if asset == nil {
+		return shim.Error("asset " + mortgage.AssetID + " " + ErrorNotFound)
+	}
+	err = checkOwner(stub, asset)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkVersion(asset.ID, asset.Version, expectedVersion)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	existing, err := getMortgage(stub, mortgage.AssetID, mortgage.ID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if existing != nil {
+		return shim.Error("mortgage " + mortgage.ID + " already exists")
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	mortgage.Status = MortgageStatusActive
+	mortgage.RegisterTxId = stub.GetTxID()
+	mortgage.RegisterTime = txTime.Format(time.RFC3339)
+	mortgage.ReleaseTxId = ""
+	mortgage.ReleaseTime = ""
+	err = putMortgage(stub, &mortgage)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	asset.IsMortgage = IsMortgageYes
+	err = putAsset(stub, asset)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(stub.GetTxID()))
+}
+
+// 解除抵押，需由资产所有者调用，资产没有其他生效中的抵押时 isMortgage 置为 0
+// assetId string required
+// mortgageId string required
+// expectedVersion string 可选，抵押记录的当前版本，不一致时报错
+func releaseMortgage(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("should have 2 or 3 args")
+	}
+	assetId, mortgageId := args[0], args[1]
+	expectedVersion := ""
+	if len(args) == 3 {
+		expectedVersion = args[2]
+	}
+	mortgage, err := getMortgage(stub, assetId, mortgageId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if mortgage == nil {
+		return shim.Error("mortgage " + mortgageId + " " + ErrorNotFound)
+	}
+	err = checkVersion(mortgageId, mortgage.Version, expectedVersion)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if mortgage.Status != MortgageStatusActive {
+		return shim.Error("mortgage " + mortgageId + " is already " + mortgage.Status)
+	}
+	asset, err := getAsset(stub, assetId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if asset == nil {
+		return shim.Error("asset " + assetId + " " + ErrorNotFound)
+	}
+	err = checkOwner(stub, asset)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	mortgage.Status = MortgageStatusReleased
+	mortgage.ReleaseTxId = stub.GetTxID()
+	mortgage.ReleaseTime = txTime.Format(time.RFC3339)
+	err = putMortgage(stub, mortgage)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// 同一交易内读不到刚写入的状态，因此统计时排除本次解除的抵押
+	mortgages, err := getMortgages(stub, assetId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	asset.IsMortgage = IsMortgageNo
+	for _, other := range mortgages {
+		if other.ID != mortgageId && other.Status == MortgageStatusActive {
+			asset.IsMortgage = IsMortgageYes
+			break
+		}
+	}
+	err = putAsset(stub, asset)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(stub.GetTxID()))
+}
+
+// 查询资产的抵押记录
+// assetId string required
+// status string 可选 active 或 released，为空时返回全部
+// res : [Mortgage]
+func getMortgagesByAssetId(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("should have 1 or 2 args")
+	}
+	assetId := args[0]
+	status := ""
+	if len(args) == 2 {
+		status = args[1]
+	}
+	if assetId == "" {
+		return shim.Error("assetId is required")
+	}
+	if status != "" && status != MortgageStatusActive && status != MortgageStatusReleased {
+		return shim.Error("status should be active or released, get " + status)
+	}
+	mortgages, err := getMortgages(stub, assetId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	data := make([]Mortgage, 0, len(mortgages))
+	for _, mortgage := range mortgages {
+		if status == "" || mortgage.Status == status {
+			data = append(data, mortgage)
+		}
+	}
+	res, err := json.Marshal(&data)
+	if err != nil {
+		return shim.Error("failed to marshal mortgages:" + err.Error())
+	}
+	return shim.Success(res)
+}
+
+func validateMortgage(mortgage *Mortgage) error {
+	if mortgage.ID == "" || mortgage.AssetID == "" || mortgage.Lender == "" {
+		return fmt.Errorf("id, assetId and lender is required")
+	}
+	if !amountPattern.MatchString(mortgage.Amount) {
+		return fmt.Errorf("amount should be a positive number with at most 2 decimals, get %s", mortgage.Amount)
+	}
+	if strings.Trim(mortgage.Amount, "0.") == "" {
+		return fmt.Errorf("amount should be greater than 0")
+	}
+	start, err := time.Parse(dateLayout, mortgage.StartDate)
+	if err != nil {
+		return fmt.Errorf("startDate should be yyyy-MM-dd, get %s", mortgage.StartDate)
+	}
+	end, err := time.Parse(dateLayout, mortgage.EndDate)
+	if err != nil {
+		return fmt.Errorf("endDate should be yyyy-MM-dd, get %s", mortgage.EndDate)
+	}
+	if end.Before(start) {
+		return fmt.Errorf("endDate should not be before startDate")
+	}
+	if !hashPattern.MatchString(mortgage.ContractHash) {
+		return fmt.Errorf("contractHash should be a lowercase hex sha256, get %s", mortgage.ContractHash)
+	}
+	return nil
+}
+
+func getMortgage(stub shim.ChaincodeStubInterface, assetId string, mortgageId string) (*Mortgage, error) {
+	key, err := stub.CreateCompositeKey(mortgageObjectType, []string{assetId, mortgageId})
+	if err != nil {
+		return nil, err
+	}
+	jsonVal, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if jsonVal == nil {
+		return nil, nil
+	}
+	mortgage := Mortgage{}
+	err = json.Unmarshal(jsonVal, &mortgage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal mortgage %s: %s", mortgageId, err.Error())
+	}
+	return &mortgage, nil
+}
+
+func getMortgages(stub shim.ChaincodeStubInterface, assetId string) ([]Mortgage, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(mortgageObjectType, []string{assetId})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	mortgages := make([]Mortgage, 0)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		mortgage := Mortgage{}
+		err = json.Unmarshal(queryResponse.Value, &mortgage)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal mortgage: %s", err.Error())
+		}
+		mortgages = append(mortgages, mortgage)
+	}
+	return mortgages, nil
+}
+
+// 写入抵押记录，版本号在已有记录的基础上加 1
+func putMortgage(stub shim.ChaincodeStubInterface, mortgage *Mortgage) error {
+	previous, err := getMortgage(stub, mortgage.AssetID, mortgage.ID)
+	if err != nil {
+		return err
+	}
+	mortgage.Version = 1
+	if previous != nil {
+		mortgage.Version = previous.Version + 1
+	}
+	key, err := stub.CreateCompositeKey(mortgageObjectType, []string{mortgage.AssetID, mortgage.ID})
+	if err != nil {
+		return err
+	}
+	jsonVal, err := json.Marshal(mortgage)
+	if err != nil {
+		return fmt.Errorf("failed to marshal mortgage: %s", err.Error())
+	}
+	return stub.PutState(key, jsonVal)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const contractHash = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+func mortgageJson(id string) string {
+	return `{"id":"` + id + `","assetId":"a1","lender":"bank","amount":"100.50","startDate":"2022-01-01","endDate":"2032-01-01","contractHash":"` + contractHash + `"}`
+}
+
+func TestMortgageLifecycle(t *testing.T) {
+	mockStub := shim.NewMockStub("asset", new(AssetFactory))
+	if _, err := invoke(mockStub, "tx1", "add", "a1", `{"id":"a1","isMortgage":"1"}`); err != nil {
+		t.Fatal(err)
+	}
+	asset, _ := getAsset(mockStub, "a1")
+	if asset.IsMortgage != IsMortgageNo {
+		t.Fatal("new asset should not be mortgaged")
+	}
+
+	if _, err := invoke(mockStub, "tx2", "registerMortgage", mortgageJson("m1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invoke(mockStub, "tx3", "registerMortgage", mortgageJson("m2")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invoke(mockStub, "tx4", "registerMortgage", mortgageJson("m1")); err == nil {
+		t.Fatal("duplicated mortgage should be rejected")
+	}
+	if _, err := invoke(mockStub, "tx5", "patch", "a1", `{"isMortgage":"0"}`); err == nil {
+		t.Fatal("isMortgage should not be patched")
+	}
+
+	if _, err := invoke(mockStub, "tx6", "releaseMortgage", "a1", "m1", "2"); err == nil {
+		t.Fatal("stale mortgage version should be rejected")
+	}
+	if _, err := invoke(mockStub, "tx6", "releaseMortgage", "a1", "m1", "1"); err != nil {
+		t.Fatal(err)
+	}
+	asset, _ = getAsset(mockStub, "a1")
+	if asset.IsMortgage != IsMortgageYes {
+		t.Fatal("asset with active mortgage should be mortgaged")
+	}
+	if _, err := invoke(mockStub, "tx7", "releaseMortgage", "a1", "m2"); err != nil {
+		t.Fatal(err)
+	}
+	asset, _ = getAsset(mockStub, "a1")
+	if asset.IsMortgage != IsMortgageNo {
+		t.Fatal("asset without active mortgage should not be mortgaged")
+	}
+
+	payload, err := invoke(mockStub, "tx8", "getMortgagesByAssetId", "a1", MortgageStatusReleased)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mortgages := make([]Mortgage, 0)
+	if err = json.Unmarshal(payload, &mortgages); err != nil {
+		t.Fatal(err)
+	}
+	if len(mortgages) != 2 || mortgages[0].ReleaseTxId != "tx6" {
+		t.Fatal(mortgages)
+	}
+}
+
+func TestValidateMortgage(t *testing.T) {
+	mortgage := Mortgage{ID: "m1", AssetID: "a1", Lender: "bank", Amount: "0.00", StartDate: "2022-01-01", EndDate: "2032-01-01", ContractHash: contractHash}
+	if err := validateMortgage(&mortgage); err == nil {
+		t.Fatal("zero amount should be rejected")
+	}
+	mortgage.Amount = "1"
+	mortgage.EndDate = "2021-01-01"
+	if err := validateMortgage(&mortgage); err == nil {
+		t.Fatal("endDate before startDate should be rejected")
+	}
+}
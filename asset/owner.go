@@ -0,0 +1,412 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	transferObjectType        = "transfer"
+	transferVersionObjectType = "transferVersion" // 每个资产的转让请求版本计数，删除转让请求时保留
+)
+
+type Owner struct {
+	MSPID   string `json:"mspId"`   // 所属组织
+	Subject string `json:"subject"` // 证书主题
+}
+
+type Transfer struct {
+	AssetID     string `json:"assetId"`     // 资产id
+	From        *Owner `json:"from"`        // 当前所有者
+	To          Owner  `json:"to"`          // 接收方
+	ExpireTime  string `json:"expireTime"`  // 过期时间 RFC3339
+	ProposeTxId string `json:"proposeTxId"` // 发起交易
+	ProposeTime string `json:"proposeTime"` // 发起时间
+	Version     int64  `json:"version"`     // 版本号，由链码维护，同一资产的转让请求每次写入或删除都加 1，不会重复
+}
+
+// 发起资产转让，需由当前所有者调用，同一资产只保留最新的一个转让请求
+// assetId string required
+// toMspId string required 接收方组织
+// toSubject string required 接收方证书主题，如 CN=user1,OU=client,O=org1
+// expireTime string required RFC3339 格式，需晚于交易时间
+// expectedVersion string 可选，待覆盖的转让请求的当前版本，没有转让请求时为上一个请求被接收或取消后的版本，从未有过时为 0，不一致时报错
+func proposeTransfer(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 4 && len(args) != 5 {
+		return shim.Error("should have 4 or 5 args")
+	}
+	assetId, toMspId, toSubject, expireTimeStr := args[0], args[1], args[2], args[3]
+	expectedVersion := ""
+	if len(args) == 5 {
+		expectedVersion = args[4]
+	}
+	if toMspId == "" || toSubject == "" {
+		return shim.Error("toMspId and toSubject is required")
+	}
+	expireTime, err := time.Parse(time.RFC3339, expireTimeStr)
+	if err != nil {
+		return shim.Error("expireTime should be RFC3339, get " + expireTimeStr)
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !expireTime.After(txTime) {
+		return shim.Error("expireTime should be after transaction time")
+	}
+
+	asset, err := getAsset(stub, assetId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if asset == nil {
+		return shim.Error(ErrorNotFound)
+	}
+	err = checkOwner(stub, asset)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	to := Owner{MSPID: toMspId, Subject: toSubject}
+	if asset.Owner != nil && *asset.Owner == to {
+		return shim.Error("asset is already owned by the recipient")
+	}
+	version, err := getTransferVersion(stub, assetId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkVersion("transfer of asset "+assetId, version, expectedVersion)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	transfer := Transfer{
+		AssetID:     assetId,
+		From:        asset.Owner,
+		To:          to,
+		ExpireTime:  expireTime.UTC().Format(time.RFC3339),
+		ProposeTxId: stub.GetTxID(),
+		ProposeTime: txTime.Format(time.RFC3339),
+	}
+	err = putTransfer(stub, &transfer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(stub.GetTxID()))
+}
+
+// 接收资产转让，需由接收方在过期前调用
+// assetId string required
+// expectedVersion string 可选，资产的当前版本，不一致时报错
+func acceptTransfer(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("should have 1 or 2 args")
+	}
+	assetId := args[0]
+	expectedVersion := ""
+	if len(args) == 2 {
+		expectedVersion = args[1]
+	}
+	transfer, err := getTransfer(stub, assetId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if transfer == nil {
+		return shim.Error("transfer of asset " + assetId + " " + ErrorNotFound)
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	expireTime, err := time.Parse(time.RFC3339, transfer.ExpireTime)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if txTime.After(expireTime) {
+		return shim.Error("transfer of asset " + assetId + " expired at " + transfer.ExpireTime)
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if *caller != transfer.To {
+		return shim.Error("only the recipient can accept the transfer")
+	}
+
+	asset, err := getAsset(stub, assetId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if asset == nil {
+		return shim.Error(ErrorNotFound)
+	}
+	if !sameOwner(asset.Owner, transfer.From) {
+		return shim.Error("owner of asset " + assetId + " changed after the transfer was proposed")
+	}
+	err = checkVersion(assetId, asset.Version, expectedVersion)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	asset.Owner = caller
+	err = putAsset(stub, asset)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = delTransfer(stub, assetId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(stub.GetTxID()))
+}
+
+// 取消资产转让，需由当前所有者调用
+// assetId string required
+// expectedVersion string 可选，转让请求的当前版本，不一致时报错
+func cancelTransfer(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("should have 1 or 2 args")
+	}
+	assetId := args[0]
+	expectedVersion := ""
+	if len(args) == 2 {
+		expectedVersion = args[1]
+	}
+	transfer, err := getTransfer(stub, assetId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if transfer == nil {
+		return shim.Error("transfer of asset " + assetId + " " + ErrorNotFound)
+	}
+	err = checkVersion("transfer of asset "+assetId, transfer.Version, expectedVersion)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	asset, err := getAsset(stub, assetId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if asset == nil {
+		return shim.Error(ErrorNotFound)
+	}
+	err = checkOwner(stub, asset)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = delTransfer(stub, assetId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(stub.GetTxID()))
+}
+
+// 查询资产待接收的转让请求
+// assetId string required
+// res : Transfer
+func getTransferByAssetId(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("should have 1 args")
+	}
+	transfer, err := getTransfer(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if transfer == nil {
+		return shim.Error(ErrorNotFound)
+	}
+	res, err := json.Marshal(transfer)
+	if err != nil {
+		return shim.Error("failed to marshal transfer:" + err.Error())
+	}
+	return shim.Success(res)
+}
+
+// 为没有所有者的资产（引入所有权之前创建或迁移的资产）指定所有者，需由 admin 调用
+// 已有所有者的资产只能通过转让修改所有者
+// assetId string required
+// mspId string required 所有者组织
+// subject string required 所有者证书主题，如 CN=user1,OU=client,O=org1
+// expectedVersion string 可选，与当前版本不一致时报错
+func assignOwner(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 3 && len(args) != 4 {
+		return shim.Error("should have 3 or 4 args")
+	}
+	assetId, mspId, subject := args[0], args[1], args[2]
+	expectedVersion := ""
+	if len(args) == 4 {
+		expectedVersion = args[3]
+	}
+	if mspId == "" || subject == "" {
+		return shim.Error("mspId and subject is required")
+	}
+	err := checkAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	asset, err := getAsset(stub, assetId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if asset == nil {
+		return shim.Error(ErrorNotFound)
+	}
+	if asset.Owner != nil {
+		return shim.Error("asset " + assetId + " already has an owner, use proposeTransfer instead")
+	}
+	err = checkVersion(assetId, asset.Version, expectedVersion)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	asset.Owner = &Owner{MSPID: mspId, Subject: subject}
+	err = putAsset(stub, asset)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// 修复前可能对没有所有者的资产发起过转让，指定所有者后作废
+	err = delTransfer(stub, assetId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(stub.GetTxID()))
+}
+
+// 调用者身份，取自客户端证书
+func getCaller(stub shim.ChaincodeStubInterface) (*Owner, error) {
+	mspId, err := cid.GetMSPID(stub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller msp id: %s", err.Error())
+	}
+	cert, err := cid.GetX509Certificate(stub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller certificate: %s", err.Error())
+	}
+	return &Owner{MSPID: mspId, Subject: cert.Subject.String()}, nil
+}
+
+// 只有所有者可以修改资产；引入所有权之前创建的资产没有所有者，需先由 admin 调用 assignOwner 指定
+func checkOwner(stub shim.ChaincodeStubInterface, asset *Asset) error {
+	if asset.Owner == nil {
+		return fmt.Errorf("asset %s has no owner, ask admin to assign one first", asset.ID)
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return err
+	}
+	if *caller != *asset.Owner {
+		return fmt.Errorf("only the owner of asset %s can modify it", asset.ID)
+	}
+	return nil
+}
+
+// 管理类操作需要调用者证书中 admin 属性为 true
+func checkAdmin(stub shim.ChaincodeStubInterface) error {
+	err := cid.AssertAttributeValue(stub, "admin", "true")
+	if err != nil {
+		return fmt.Errorf("only admin can do this: %s", err.Error())
+	}
+	return nil
+}
+
+// 没有所有者的资产不能转让，nil 与任何所有者都不相同
+func sameOwner(a, b *Owner) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	return *a == *b
+}
+
+func getTransfer(stub shim.ChaincodeStubInterface, assetId string) (*Transfer, error) {
+	key, err := stub.CreateCompositeKey(transferObjectType, []string{assetId})
+	if err != nil {
+		return nil, err
+	}
+	jsonVal, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if jsonVal == nil {
+		return nil, nil
+	}
+	transfer := Transfer{}
+	err = json.Unmarshal(jsonVal, &transfer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal transfer: %s", err.Error())
+	}
+	return &transfer, nil
+}
+
+// 写入转让请求，版本号在版本计数的基础上加 1
+func putTransfer(stub shim.ChaincodeStubInterface, transfer *Transfer) error {
+	version, err := nextTransferVersion(stub, transfer.AssetID)
+	if err != nil {
+		return err
+	}
+	transfer.Version = version
+	key, err := stub.CreateCompositeKey(transferObjectType, []string{transfer.AssetID})
+	if err != nil {
+		return err
+	}
+	jsonVal, err := json.Marshal(transfer)
+	if err != nil {
+		return fmt.Errorf("failed to marshal transfer: %s", err.Error())
+	}
+	return stub.PutState(key, jsonVal)
+}
+
+// 删除转让请求时版本计数同样加 1，之后新发起的请求版本不会与被删除的请求重复
+func delTransfer(stub shim.ChaincodeStubInterface, assetId string) error {
+	_, err := nextTransferVersion(stub, assetId)
+	if err != nil {
+		return err
+	}
+	key, err := stub.CreateCompositeKey(transferObjectType, []string{assetId})
+	if err != nil {
+		return err
+	}
+	return stub.DelState(key)
+}
+
+// 资产转让请求的当前版本；引入版本计数之前没有计数记录，取现有请求的版本，没有请求时为 0
+func getTransferVersion(stub shim.ChaincodeStubInterface, assetId string) (int64, error) {
+	key, err := stub.CreateCompositeKey(transferVersionObjectType, []string{assetId})
+	if err != nil {
+		return 0, err
+	}
+	jsonVal, err := stub.GetState(key)
+	if err != nil {
+		return 0, err
+	}
+	if jsonVal != nil {
+		version, err := strconv.ParseInt(string(jsonVal), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse transfer version of asset %s: %s", assetId, err.Error())
+		}
+		return version, nil
+	}
+	transfer, err := getTransfer(stub, assetId)
+	if err != nil {
+		return 0, err
+	}
+	if transfer == nil {
+		return 0, nil
+	}
+	return transfer.Version, nil
+}
+
+func nextTransferVersion(stub shim.ChaincodeStubInterface, assetId string) (int64, error) {
+	version, err := getTransferVersion(stub, assetId)
+	if err != nil {
+		return 0, err
+	}
+	version++
+	key, err := stub.CreateCompositeKey(transferVersionObjectType, []string{assetId})
+	if err != nil {
+		return 0, err
+	}
+	return version, stub.PutState(key, []byte(strconv.FormatInt(version, 10)))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestTransfer(t *testing.T) {
+	mockStub := shim.NewMockStub("asset", new(AssetFactory))
+	other := newCreator("Org2MSP", "user2", nil)
+	if _, err := invoke(mockStub, "tx1", "add", "a1", `{"id":"a1"}`); err != nil {
+		t.Fatal(err)
+	}
+	asset, _ := getAsset(mockStub, "a1")
+	if asset.Owner == nil || asset.Owner.MSPID != "Org1MSP" || asset.Owner.Subject != "CN=user1,O=Org1MSP" {
+		t.Fatal(asset.Owner)
+	}
+	if _, err := invokeAs(mockStub, other, "tx2", "patch", "a1", `{"remark":"remark"}`); err == nil {
+		t.Fatal("only the owner can patch the asset")
+	}
+
+	expireTime := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	if _, err := invokeAs(mockStub, other, "tx3", "proposeTransfer", "a1", "Org2MSP", "CN=user2,O=Org2MSP", expireTime); err == nil {
+		t.Fatal("only the owner can propose a transfer")
+	}
+	if _, err := invoke(mockStub, "tx4", "proposeTransfer", "a1", "Org2MSP", "CN=user2,O=Org2MSP", expireTime, "0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invoke(mockStub, "tx4", "proposeTransfer", "a1", "Org2MSP", "CN=user2,O=Org2MSP", expireTime, "0"); err == nil {
+		t.Fatal("existing transfer should not be overwritten with a stale version")
+	}
+	if _, err := invoke(mockStub, "tx4", "proposeTransfer", "a1", "Org2MSP", "CN=user2,O=Org2MSP", expireTime, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invoke(mockStub, "tx4", "cancelTransfer", "a1", "1"); err == nil {
+		t.Fatal("stale transfer version should be rejected")
+	}
+	if _, err := invoke(mockStub, "tx5", "acceptTransfer", "a1"); err == nil {
+		t.Fatal("only the recipient can accept the transfer")
+	}
+	if _, err := invokeAs(mockStub, other, "tx6", "acceptTransfer", "a1"); err != nil {
+		t.Fatal(err)
+	}
+	asset, _ = getAsset(mockStub, "a1")
+	if asset.Owner.MSPID != "Org2MSP" {
+		t.Fatal(asset.Owner)
+	}
+	if _, err := invokeAs(mockStub, other, "tx7", "patch", "a1", `{"remark":"remark"}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invoke(mockStub, "tx8", "getTransferByAssetId", "a1"); err == nil {
+		t.Fatal("accepted transfer should be removed")
+	}
+}
+
+func TestTransferVersionAfterCancel(t *testing.T) {
+	mockStub := shim.NewMockStub("asset", new(AssetFactory))
+	if _, err := invoke(mockStub, "tx1", "add", "a1", `{"id":"a1"}`); err != nil {
+		t.Fatal(err)
+	}
+	expireTime := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	if _, err := invoke(mockStub, "tx2", "proposeTransfer", "a1", "Org2MSP", "CN=user2,O=Org2MSP", expireTime, "0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invoke(mockStub, "tx3", "cancelTransfer", "a1", "1"); err != nil {
+		t.Fatal(err)
+	}
+	// 取消后版本不回到 0，基于被取消的请求的版本也不能再覆盖
+	if _, err := invoke(mockStub, "tx4", "proposeTransfer", "a1", "Org2MSP", "CN=user2,O=Org2MSP", expireTime, "0"); err == nil {
+		t.Fatal("version should not restart after cancel")
+	}
+	if _, err := invoke(mockStub, "tx5", "proposeTransfer", "a1", "Org3MSP", "CN=user3,O=Org3MSP", expireTime, "2"); err != nil {
+		t.Fatal(err)
+	}
+	transfer, _ := getTransfer(mockStub, "a1")
+	if transfer.Version != 3 {
+		t.Fatal(transfer)
+	}
+	if _, err := invoke(mockStub, "tx6", "proposeTransfer", "a1", "Org2MSP", "CN=user2,O=Org2MSP", expireTime, "1"); err == nil {
+		t.Fatal("stale version of the cancelled transfer should be rejected")
+	}
+	if _, err := invoke(mockStub, "tx7", "cancelTransfer", "a1", "1"); err == nil {
+		t.Fatal("stale version of the cancelled transfer should be rejected")
+	}
+	if transfer, _ = getTransfer(mockStub, "a1"); transfer.To.MSPID != "Org3MSP" {
+		t.Fatal(transfer)
+	}
+}
+
+func TestTransferExpired(t *testing.T) {
+	mockStub := shim.NewMockStub("asset", new(AssetFactory))
+	other := newCreator("Org2MSP", "user2", nil)
+	if _, err := invoke(mockStub, "tx1", "add", "a1", `{"id":"a1"}`); err != nil {
+		t.Fatal(err)
+	}
+	asset, _ := getAsset(mockStub, "a1")
+	mockStub.MockTransactionStart("tx2")
+	err := putTransfer(mockStub, &Transfer{
+		AssetID:    "a1",
+		From:       asset.Owner,
+		To:         Owner{MSPID: "Org2MSP", Subject: "CN=user2,O=Org2MSP"},
+		ExpireTime: time.Now().Add(-time.Minute).UTC().Format(time.RFC3339),
+	})
+	mockStub.MockTransactionEnd("tx2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invokeAs(mockStub, other, "tx3", "acceptTransfer", "a1"); err == nil {
+		t.Fatal("expired transfer should be rejected")
+	}
+}
+
+func TestOwnerlessAsset(t *testing.T) {
+	mockStub := shim.NewMockStub("asset", new(AssetFactory))
+	stranger := newCreator("Org2MSP", "user2", nil)
+	admin := newCreator("Org1MSP", "admin", map[string]string{"admin": "true"})
+	// 引入所有权之前创建的资产没有所有者
+	mockStub.MockTransactionStart("tx1")
+	err := putAsset(mockStub, &Asset{ID: "a1"})
+	mockStub.MockTransactionEnd("tx1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expireTime := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	if _, err := invokeAs(mockStub, stranger, "tx2", "proposeTransfer", "a1", "Org2MSP", "CN=user2,O=Org2MSP", expireTime); err == nil {
+		t.Fatal("asset without owner should not be transferred")
+	}
+	if _, err := invokeAs(mockStub, stranger, "tx3", "patch", "a1", `{"remark":"remark"}`); err == nil {
+		t.Fatal("asset without owner should not be patched")
+	}
+	if _, err := invokeAs(mockStub, stranger, "tx4", "assignOwner", "a1", "Org2MSP", "CN=user2,O=Org2MSP"); err == nil {
+		t.Fatal("only admin can assign owner")
+	}
+	if _, err := invokeAs(mockStub, admin, "tx5", "assignOwner", "a1", "Org1MSP", "CN=user1,O=Org1MSP"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invokeAs(mockStub, admin, "tx6", "assignOwner", "a1", "Org2MSP", "CN=user2,O=Org2MSP"); err == nil {
+		t.Fatal("owned asset should only be transferred")
+	}
+	if _, err := invoke(mockStub, "tx7", "patch", "a1", `{"remark":"remark"}`); err != nil {
+		t.Fatal(err)
+	}
+}
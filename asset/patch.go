@@ -0,0 +1,432 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+type PatchOperation struct {
+	Op    string          `json:"op"`   // add、remove、replace、move、copy 或 test
+	Path  string          `json:"path"` // JSON Pointer，如 /remark
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// 由链码维护的字段，patch 和 jsonPatch 不能修改，但可以在 test 中使用
+var derivedAssetFields = map[string]string{
+	"id":         "id can not be changed",
+	"isMortgage": "isMortgage is derived from mortgage records, use registerMortgage or releaseMortgage",
+	"owner":      "owner can only be changed by transfer",
+	"geo":        "geo is derived from longitude and latitude",
+	"version":    "version is maintained by the chaincode, pass expectedVersion instead",
+}
+
+// 按 RFC 7396 merge patch 修改资产，需由所有者调用
+// id string required
+// patch string required 如 {"remark":"备注","houseCert":null}，null 表示清空字段
+// expectedVersion string 可选，与当前版本不一致时报错
+func patch(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("should have 2 or 3 args")
+	}
+	id, patchValue := args[0], args[1]
+	expectedVersion := ""
+	if len(args) == 3 {
+		expectedVersion = args[2]
+	}
+	var mergePatch interface{}
+	err := json.Unmarshal([]byte(patchValue), &mergePatch)
+	if err != nil {
+		return shim.Error("invalid merge patch: " + err.Error())
+	}
+	patchMap, ok := mergePatch.(map[string]interface{})
+	if !ok {
+		return shim.Error("merge patch should be a json object")
+	}
+	fields := assetFields()
+	for field := range patchMap {
+		if _, ok := fields[field]; !ok {
+			return shim.Error("unknown field " + field)
+		}
+	}
+	return patchAsset(stub, id, expectedVersion, func(doc interface{}) (interface{}, error) {
+		return applyMergePatch(doc, mergePatch), nil
+	})
+}
+
+// 按 RFC 6902 JSON Patch 修改资产，需由所有者调用；任一操作失败（包括 test 不满足）时整体不生效
+// id string required
+// operations string required 如 [{"op":"test","path":"/remark","value":""},{"op":"replace","path":"/remark","value":"备注"}]
+// expectedVersion string 可选，与当前版本不一致时报错
+func jsonPatch(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("should have 2 or 3 args")
+	}
+	id, patchValue := args[0], args[1]
+	expectedVersion := ""
+	if len(args) == 3 {
+		expectedVersion = args[2]
+	}
+	operations := make([]PatchOperation, 0)
+	decoder := json.NewDecoder(strings.NewReader(patchValue))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&operations)
+	if err != nil {
+		return shim.Error("invalid json patch: " + err.Error())
+	}
+	if len(operations) == 0 {
+		return shim.Error("json patch should have at least 1 operation")
+	}
+	return patchAsset(stub, id, expectedVersion, func(doc interface{}) (interface{}, error) {
+		return applyJsonPatch(doc, operations)
+	})
+}
+
+// patch 和 jsonPatch 的公共流程：资产转为 json 文档，应用修改后按 Asset 严格解析并写入
+func patchAsset(stub shim.ChaincodeStubInterface, id string, expectedVersion string, apply func(doc interface{}) (interface{}, error)) peer.Response {
+	asset, err := getAsset(stub, id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if asset == nil {
+		return shim.Error(ErrorNotFound)
+	}
+	err = checkOwner(stub, asset)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkVersion(id, asset.Version, expectedVersion)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	doc, err := assetToDocument(asset)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	patched, err := apply(copyDocument(doc))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	patchedMap, ok := patched.(map[string]interface{})
+	if !ok {
+		return shim.Error("patched asset should be a json object")
+	}
+	fields := assetFields()
+	for field := range patchedMap {
+		if _, ok := fields[field]; !ok {
+			return shim.Error("unknown field " + field)
+		}
+	}
+	for field, message := range derivedAssetFields {
+		if !reflect.DeepEqual(doc[field], patchedMap[field]) {
+			return shim.Error(message)
+		}
+	}
+
+	jsonVal, err := json.Marshal(patchedMap)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	point, err := decodeAsset(id, string(jsonVal))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if point.RentableArea != asset.RentableArea {
+		err = checkRentableArea(stub, point)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	err = putAsset(stub, point)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(stub.GetTxID()))
+}
+
+// Asset 的 json 字段名及其零值
+func assetFields() map[string]interface{} {
+	fields := make(map[string]interface{})
+	assetType := reflect.TypeOf(Asset{})
+	for i := 0; i < assetType.NumField(); i++ {
+		field := assetType.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		jsonVal, _ := json.Marshal(reflect.Zero(field.Type).Interface())
+		var zero interface{}
+		_ = json.Unmarshal(jsonVal, &zero)
+		fields[name] = zero
+	}
+	return fields
+}
+
+// 转为 json 文档，省略的字段补为零值，使所有已知字段都可以 replace、remove 和 test
+func assetToDocument(asset *Asset) (map[string]interface{}, error) {
+	doc, err := assetToMap(asset)
+	if err != nil {
+		return nil, err
+	}
+	for field, zero := range assetFields() {
+		if _, ok := doc[field]; !ok {
+			doc[field] = zero
+		}
+	}
+	return doc, nil
+}
+
+// RFC 7396：对象逐字段合并，null 删除字段，其余值整体替换
+func applyMergePatch(target interface{}, mergePatch interface{}) interface{} {
+	patchMap, ok := mergePatch.(map[string]interface{})
+	if !ok {
+		return mergePatch
+	}
+	targetMap, ok := target.(map[string]interface{})
+	if !ok {
+		targetMap = make(map[string]interface{})
+	}
+	for key, value := range patchMap {
+		if value == nil {
+			delete(targetMap, key)
+		} else {
+			targetMap[key] = applyMergePatch(targetMap[key], value)
+		}
+	}
+	return targetMap
+}
+
+// RFC 6902：按顺序执行操作，返回修改后的文档
+func applyJsonPatch(doc interface{}, operations []PatchOperation) (interface{}, error) {
+	for i, operation := range operations {
+		var err error
+		doc, err = applyPatchOperation(doc, operation)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %s", i, operation.Op, operation.Path, err.Error())
+		}
+	}
+	return doc, nil
+}
+
+func applyPatchOperation(doc interface{}, operation PatchOperation) (interface{}, error) {
+	path, err := parsePointer(operation.Path)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	switch operation.Op {
+	case "add", "replace", "test":
+		if operation.Value == nil {
+			return nil, fmt.Errorf("value is required")
+		}
+		err = json.Unmarshal(operation.Value, &value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value: %s", err.Error())
+		}
+	case "move", "copy":
+		from, err := parsePointer(operation.From)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from: %s", err.Error())
+		}
+		value, err = getPointer(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if operation.Op == "copy" {
+			value = copyDocument(value)
+			break
+		}
+		if len(from) < len(path) && reflect.DeepEqual(from, path[:len(from)]) {
+			return nil, fmt.Errorf("can not move %s into its child", operation.From)
+		}
+		doc, err = removePointer(doc, from)
+		if err != nil {
+			return nil, err
+		}
+	case "remove":
+	default:
+		return nil, fmt.Errorf("unsupported op %s", operation.Op)
+	}
+
+	switch operation.Op {
+	case "add", "move", "copy":
+		return addPointer(doc, path, value)
+	case "remove":
+		return removePointer(doc, path)
+	case "replace":
+		_, err = getPointer(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if len(path) == 0 {
+			return value, nil
+		}
+		doc, err = removePointer(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		return addPointer(doc, path, value)
+	default:
+		current, err := getPointer(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, fmt.Errorf("test failed, current value is %s", mustMarshal(current))
+		}
+		return doc, nil
+	}
+}
+
+// RFC 6901 JSON Pointer，"" 表示整个文档
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("path should start with /, get %s", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+func getPointer(doc interface{}, path []string) (interface{}, error) {
+	for i, token := range path {
+		switch container := doc.(type) {
+		case map[string]interface{}:
+			value, ok := container[token]
+			if !ok {
+				return nil, fmt.Errorf("path %s does not exist", formatPointer(path[:i+1]))
+			}
+			doc = value
+		case []interface{}:
+			index, err := arrayIndex(token, len(container)-1)
+			if err != nil {
+				return nil, fmt.Errorf("path %s does not exist: %s", formatPointer(path[:i+1]), err.Error())
+			}
+			doc = container[index]
+		default:
+			return nil, fmt.Errorf("path %s does not exist", formatPointer(path[:i+1]))
+		}
+	}
+	return doc, nil
+}
+
+func addPointer(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	parent, err := getPointer(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	token := path[len(path)-1]
+	switch container := parent.(type) {
+	case map[string]interface{}:
+		container[token] = value
+		return doc, nil
+	case []interface{}:
+		index := len(container)
+		if token != "-" {
+			index, err = arrayIndex(token, len(container))
+			if err != nil {
+				return nil, fmt.Errorf("path %s: %s", formatPointer(path), err.Error())
+			}
+		}
+		array := append(container[:index:index], value)
+		array = append(array, container[index:]...)
+		return setPointer(doc, path[:len(path)-1], array), nil
+	default:
+		return nil, fmt.Errorf("path %s does not exist", formatPointer(path[:len(path)-1]))
+	}
+}
+
+func removePointer(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("can not remove the whole document")
+	}
+	_, err := getPointer(doc, path)
+	if err != nil {
+		return nil, err
+	}
+	parent, _ := getPointer(doc, path[:len(path)-1])
+	token := path[len(path)-1]
+	switch container := parent.(type) {
+	case map[string]interface{}:
+		delete(container, token)
+		return doc, nil
+	case []interface{}:
+		index, _ := arrayIndex(token, len(container)-1)
+		array := append(container[:index:index], container[index+1:]...)
+		return setPointer(doc, path[:len(path)-1], array), nil
+	}
+	return doc, nil
+}
+
+// 数组长度变化后需要写回父节点，path 已确认存在
+func setPointer(doc interface{}, path []string, value interface{}) interface{} {
+	if len(path) == 0 {
+		return value
+	}
+	parent, _ := getPointer(doc, path[:len(path)-1])
+	token := path[len(path)-1]
+	switch container := parent.(type) {
+	case map[string]interface{}:
+		container[token] = value
+	case []interface{}:
+		index, _ := strconv.Atoi(token)
+		container[index] = value
+	}
+	return doc
+}
+
+func arrayIndex(token string, max int) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %s", token)
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || index > max {
+		return 0, fmt.Errorf("array index %s out of range", token)
+	}
+	return index, nil
+}
+
+func formatPointer(path []string) string {
+	var builder strings.Builder
+	for _, token := range path {
+		builder.WriteString("/")
+		builder.WriteString(strings.Replace(strings.Replace(token, "~", "~0", -1), "/", "~1", -1))
+	}
+	return builder.String()
+}
+
+func copyDocument(doc interface{}) interface{} {
+	switch value := doc.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			copied[key] = copyDocument(item)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(value))
+		for i, item := range value {
+			copied[i] = copyDocument(item)
+		}
+		return copied
+	default:
+		return value
+	}
+}
+
+func mustMarshal(value interface{}) string {
+	jsonVal, _ := json.Marshal(value)
+	return string(jsonVal)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestMergePatch(t *testing.T) {
+	mockStub := shim.NewMockStub("asset", new(AssetFactory))
+	if _, err := invoke(mockStub, "tx1", "add", "a1", `{"id":"a1","houseCert":"h1","remark":"r1"}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invoke(mockStub, "tx2", "patch", "a1", `{"houseCert":null,"remark":"r2"}`); err != nil {
+		t.Fatal(err)
+	}
+	asset, _ := getAsset(mockStub, "a1")
+	if asset.HouseCert != "" || asset.Remark != "r2" {
+		t.Fatal(asset)
+	}
+
+	rejected := map[string]string{
+		`{"unknown":"x"}`:     "unknown field unknown",
+		`{"unknown":null}`:    "unknown field unknown",
+		`{"area":120}`:        "cannot unmarshal number",
+		`{"owner":null}`:      "owner can only be changed by transfer",
+		`{"id":"a2"}`:         "id can not be changed",
+		`["remark"]`:          "merge patch should be a json object",
+		`{"isMortgage":"1"}`:  "isMortgage is derived",
+		`{"geo":{"lng":1.0}}`: "geo is derived",
+	}
+	for patchValue, message := range rejected {
+		_, err := invoke(mockStub, "tx3", "patch", "a1", patchValue)
+		if err == nil || !strings.Contains(err.Error(), message) {
+			t.Fatal(patchValue, err)
+		}
+	}
+}
+
+func TestJsonPatch(t *testing.T) {
+	mockStub := shim.NewMockStub("asset", new(AssetFactory))
+	if _, err := invoke(mockStub, "tx1", "add", "a1", `{"id":"a1","remark":"r1"}`); err != nil {
+		t.Fatal(err)
+	}
+	conditional := `[{"op":"test","path":"/isMortgage","value":"0"},{"op":"test","path":"/remark","value":"r1"},{"op":"replace","path":"/remark","value":"r2"}]`
+	if _, err := invoke(mockStub, "tx2", "jsonPatch", "a1", conditional); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invoke(mockStub, "tx3", "jsonPatch", "a1", conditional); err == nil || !strings.Contains(err.Error(), "test failed") {
+		t.Fatal("test operation should fail after remark changed", err)
+	}
+	asset, _ := getAsset(mockStub, "a1")
+	if asset.Remark != "r2" {
+		t.Fatal(asset)
+	}
+
+	if _, err := invoke(mockStub, "tx4", "jsonPatch", "a1", `[{"op":"move","from":"/remark","path":"/houseCert"},{"op":"remove","path":"/location"}]`); err != nil {
+		t.Fatal(err)
+	}
+	asset, _ = getAsset(mockStub, "a1")
+	if asset.Remark != "" || asset.HouseCert != "r2" {
+		t.Fatal(asset)
+	}
+
+	rejected := map[string]string{
+		`[{"op":"replace","path":"/unknown","value":"x"}]`:    "path /unknown does not exist",
+		`[{"op":"add","path":"/unknown","value":"x"}]`:        "unknown field unknown",
+		`[{"op":"replace","path":"/isMortgage","value":"1"}]`: "isMortgage is derived",
+		`[{"op":"replace","path":"remark","value":"x"}]`:      "path should start with /",
+		`[{"op":"increment","path":"/remark"}]`:               "unsupported op increment",
+		`[{"op":"replace","path":"/remark"}]`:                 "value is required",
+		`[]`:                                                  "at least 1 operation",
+	}
+	for operations, message := range rejected {
+		_, err := invoke(mockStub, "tx5", "jsonPatch", "a1", operations)
+		if err == nil || !strings.Contains(err.Error(), message) {
+			t.Fatal(operations, err)
+		}
+	}
+}
+
+func TestApplyJsonPatch(t *testing.T) {
+	var doc interface{}
+	_ = json.Unmarshal([]byte(`{"a":{"b~c":[1,2,3]},"d":"x"}`), &doc)
+	operations := make([]PatchOperation, 0)
+	_ = json.Unmarshal([]byte(`[
+		{"op":"add","path":"/a/b~0c/1","value":9},
+		{"op":"remove","path":"/a/b~0c/0"},
+		{"op":"add","path":"/a/b~0c/-","value":4},
+		{"op":"copy","from":"/a/b~0c","path":"/e"},
+		{"op":"replace","path":"/e/0","value":"y"},
+		{"op":"move","from":"/d","path":"/a/d~1f"},
+		{"op":"test","path":"/a/b~0c","value":[9,2,3,4]}
+	]`), &operations)
+	patched, err := applyJsonPatch(doc, operations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected interface{}
+	_ = json.Unmarshal([]byte(`{"a":{"b~c":[9,2,3,4],"d/f":"x"},"e":["y",2,3,4]}`), &expected)
+	if !reflect.DeepEqual(patched, expected) {
+		t.Fatal(mustMarshal(patched))
+	}
+
+	for _, operation := range []string{
+		`{"op":"add","path":"/a/b~0c/5","value":1}`,
+		`{"op":"remove","path":"/a/b~0c/01"}`,
+		`{"op":"move","from":"/a","path":"/a/x"}`,
+		`{"op":"remove","path":""}`,
+	} {
+		invalid := PatchOperation{}
+		_ = json.Unmarshal([]byte(operation), &invalid)
+		if _, err := applyJsonPatch(patched, []PatchOperation{invalid}); err == nil {
+			t.Fatal(operation + " should fail")
+		}
+	}
+}
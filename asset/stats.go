@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	areaStatsObjectType = "areaStats"
+	// 已计入面积汇总的资产，引入汇总之前写入的资产没有该标记，修改时不能从汇总中减去旧值
+	areaStatsCountedObjectType = "areaStatsCounted"
+
+	// 单个资产的面积上限，超出时视为无效数据
+	maxStatsArea = 1e12
+)
+
+// 汇总的维度，对应 Asset 的 json 字段
+var statsDimensions = []string{"location", "assetType", "account"}
+
+type AreaStats struct {
+	Dimension      string `json:"dimension"` // location、assetType 或 account
+	Group          string `json:"group"`     // 维度的取值
+	Count          int64  `json:"count"`     // 资产数量
+	Area           string `json:"area"`
+	FloorArea      string `json:"floorArea"`
+	RentableArea   string `json:"rentableArea"`
+	UnrentableArea string `json:"unrentableArea"`
+}
+
+// 以 0.0001 平方米为单位累加，避免浮点误差；面积为空、不是数字或超出范围时按 0 计算
+type areaTotals struct {
+	count          int64
+	area           int64
+	floorArea      int64
+	rentableArea   int64
+	unrentableArea int64
+}
+
+// 查询面积汇总，在写入资产时增量维护
+// dimension string required location、assetType 或 account
+// group string 可选，为空时返回该维度的全部分组
+// res : [AreaStats]
+func getAreaStats(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("should have 1 or 2 args")
+	}
+	dimension := args[0]
+	if !isStatsDimension(dimension) {
+		return shim.Error("dimension should be one of " + strings.Join(statsDimensions, ", ") + ", get " + dimension)
+	}
+	attributes := []string{dimension}
+	if len(args) == 2 {
+		attributes = append(attributes, args[1])
+	}
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(areaStatsObjectType, attributes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	data := make([]AreaStats, 0)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error("failed get resultsIterator:" + err.Error())
+		}
+		stats := AreaStats{}
+		err = json.Unmarshal(queryResponse.Value, &stats)
+		if err != nil {
+			return shim.Error("failed to unmarshal area stats:" + err.Error())
+		}
+		data = append(data, stats)
+	}
+	res, err := json.Marshal(&data)
+	if err != nil {
+		return shim.Error("failed to marshal area stats:" + err.Error())
+	}
+	return shim.Success(res)
+}
+
+// 全量扫描资产重建面积汇总，需由 admin 属性为 true 的身份调用，资产较多时交易较大
+// res : 重建后的分组数量
+func rebuildAreaStats(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 0 {
+		return shim.Error("should have 0 args")
+	}
+	err := checkAdmin(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	existingIterator, err := stub.GetStateByPartialCompositeKey(areaStatsObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer existingIterator.Close()
+	for existingIterator.HasNext() {
+		queryResponse, err := existingIterator.Next()
+		if err != nil {
+			return shim.Error("failed get resultsIterator:" + err.Error())
+		}
+		err = stub.DelState(queryResponse.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	assetIterator, err := stub.GetStateByPartialCompositeKey(assetObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer assetIterator.Close()
+	groups := make(map[[2]string]*areaTotals)
+	for assetIterator.HasNext() {
+		queryResponse, err := assetIterator.Next()
+		if err != nil {
+			return shim.Error("failed get resultsIterator:" + err.Error())
+		}
+		asset := Asset{}
+		err = json.Unmarshal(queryResponse.Value, &asset)
+		if err != nil {
+			return shim.Error("failed to unmarshal asset " + queryResponse.Key + ":" + err.Error())
+		}
+		for _, dimension := range statsDimensions {
+			group := [2]string{dimension, statsGroup(&asset, dimension)}
+			if groups[group] == nil {
+				groups[group] = &areaTotals{}
+			}
+			groups[group].add(assetAreaTotals(&asset), 1)
+		}
+		err = markAreaStatsCounted(stub, asset.ID)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	keys := make([][2]string, 0, len(groups))
+	for group := range groups {
+		keys = append(keys, group)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0] < keys[j][0] || keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1]
+	})
+	for _, group := range keys {
+		err = putAreaStats(stub, group[0], group[1], groups[group])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	return shim.Success([]byte(fmt.Sprint(len(keys))))
+}
+
+// 写入资产时调用，从旧分组中减去旧值，在新分组中加上新值；旧值未计入汇总时只加新值
+func updateAreaStats(stub shim.ChaincodeStubInterface, previous *Asset, asset *Asset) error {
+	counted, err := areaStatsCounted(stub, asset.ID)
+	if err != nil {
+		return err
+	}
+	for _, dimension := range statsDimensions {
+		if previous != nil && counted {
+			err := addAreaStats(stub, dimension, statsGroup(previous, dimension), assetAreaTotals(previous), -1)
+			if err != nil {
+				return err
+			}
+		}
+		err := addAreaStats(stub, dimension, statsGroup(asset, dimension), assetAreaTotals(asset), 1)
+		if err != nil {
+			return err
+		}
+	}
+	if counted {
+		return nil
+	}
+	return markAreaStatsCounted(stub, asset.ID)
+}
+
+func areaStatsCounted(stub shim.ChaincodeStubInterface, id string) (bool, error) {
+	key, err := stub.CreateCompositeKey(areaStatsCountedObjectType, []string{id})
+	if err != nil {
+		return false, err
+	}
+	jsonVal, err := stub.GetState(key)
+	if err != nil {
+		return false, err
+	}
+	return jsonVal != nil, nil
+}
+
+func markAreaStatsCounted(stub shim.ChaincodeStubInterface, id string) error {
+	counted, err := areaStatsCounted(stub, id)
+	if err != nil || counted {
+		return err
+	}
+	key, err := stub.CreateCompositeKey(areaStatsCountedObjectType, []string{id})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, []byte(id))
+}
+
+func addAreaStats(stub shim.ChaincodeStubInterface, dimension string, group string, totals *areaTotals, sign int64) error {
+	current, err := getAreaTotals(stub, dimension, group)
+	if err != nil {
+		return err
+	}
+	current.add(totals, sign)
+	if current.count <= 0 {
+		key, err := stub.CreateCompositeKey(areaStatsObjectType, []string{dimension, group})
+		if err != nil {
+			return err
+		}
+		return stub.DelState(key)
+	}
+	return putAreaStats(stub, dimension, group, current)
+}
+
+func getAreaTotals(stub shim.ChaincodeStubInterface, dimension string, group string) (*areaTotals, error) {
+	key, err := stub.CreateCompositeKey(areaStatsObjectType, []string{dimension, group})
+	if err != nil {
+		return nil, err
+	}
+	jsonVal, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	totals := &areaTotals{}
+	if jsonVal == nil {
+		return totals, nil
+	}
+	stats := AreaStats{}
+	err = json.Unmarshal(jsonVal, &stats)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal area stats %s %s: %s", dimension, group, err.Error())
+	}
+	totals.count = stats.Count
+	totals.area = areaUnits(stats.Area)
+	totals.floorArea = areaUnits(stats.FloorArea)
+	totals.rentableArea = areaUnits(stats.RentableArea)
+	totals.unrentableArea = areaUnits(stats.UnrentableArea)
+	return totals, nil
+}
+
+func putAreaStats(stub shim.ChaincodeStubInterface, dimension string, group string, totals *areaTotals) error {
+	key, err := stub.CreateCompositeKey(areaStatsObjectType, []string{dimension, group})
+	if err != nil {
+		return err
+	}
+	stats := AreaStats{
+		Dimension:      dimension,
+		Group:          group,
+		Count:          totals.count,
+		Area:           formatAreaUnits(totals.area),
+		FloorArea:      formatAreaUnits(totals.floorArea),
+		RentableArea:   formatAreaUnits(totals.rentableArea),
+		UnrentableArea: formatAreaUnits(totals.unrentableArea),
+	}
+	jsonVal, err := json.Marshal(&stats)
+	if err != nil {
+		return fmt.Errorf("failed to marshal area stats: %s", err.Error())
+	}
+	return stub.PutState(key, jsonVal)
+}
+
+func (totals *areaTotals) add(other *areaTotals, sign int64) {
+	totals.count += other.count * sign
+	totals.area += other.area * sign
+	totals.floorArea += other.floorArea * sign
+	totals.rentableArea += other.rentableArea * sign
+	totals.unrentableArea += other.unrentableArea * sign
+}
+
+func assetAreaTotals(asset *Asset) *areaTotals {
+	return &areaTotals{
+		count:          1,
+		area:           assetAreaUnits(asset.Area),
+		floorArea:      assetAreaUnits(asset.FloorArea),
+		rentableArea:   assetAreaUnits(asset.RentableArea),
+		unrentableArea: assetAreaUnits(asset.UnrentableArea),
+	}
+}
+
+func statsGroup(asset *Asset, dimension string) string {
+	switch dimension {
+	case "location":
+		return asset.Location
+	case "assetType":
+		return asset.AssetType
+	default:
+		return asset.Account
+	}
+}
+
+func isStatsDimension(dimension string) bool {
+	for _, d := range statsDimensions {
+		if d == dimension {
+			return true
+		}
+	}
+	return false
+}
+
+func assetAreaUnits(value string) int64 {
+	units, err := parseAreaUnits("area", value)
+	if err != nil {
+		return 0
+	}
+	return units
+}
+
+// 汇总值由 formatAreaUnits 生成，不会出错
+func areaUnits(value string) int64 {
+	units, _ := decimalAreaUnits(value)
+	return units
+}
+
+// 按整数拼接十进制字符串，去掉小数末尾的 0，如 1007500 为 "100.75"
+func formatAreaUnits(units int64) string {
+	sign := ""
+	if units < 0 {
+		sign, units = "-", -units
+	}
+	area := fmt.Sprintf("%d.%04d", units/10000, units%10000)
+	return sign + strings.TrimRight(strings.TrimRight(area, "0"), ".")
+}
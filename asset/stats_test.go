@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func areaStats(t *testing.T, mockStub *shim.MockStub, args ...string) []AreaStats {
+	payload, err := invoke(mockStub, "query", append([]string{"getAreaStats"}, args...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := make([]AreaStats, 0)
+	_ = json.Unmarshal(payload, &stats)
+	return stats
+}
+
+func TestAreaStats(t *testing.T) {
+	mockStub := shim.NewMockStub("asset", new(AssetFactory))
+	assets := `[{"id":"a1","location":"east","assetType":"house","area":"100.5","rentableArea":"80"},` +
+		`{"id":"a2","location":"east","assetType":"land","area":"0.25","floorArea":"300"},` +
+		`{"id":"a3","location":"west","assetType":"house","area":"not a number"}]`
+	if _, err := invoke(mockStub, "tx1", "batchAdd", assets); err != nil {
+		t.Fatal(err)
+	}
+	east := areaStats(t, mockStub, "location", "east")
+	if len(east) != 1 || east[0].Count != 2 || east[0].Area != "100.75" || east[0].FloorArea != "300" || east[0].RentableArea != "80" {
+		t.Fatal(east)
+	}
+	if houses := areaStats(t, mockStub, "assetType", "house"); houses[0].Count != 2 || houses[0].Area != "100.5" {
+		t.Fatal(houses)
+	}
+
+	if _, err := invoke(mockStub, "tx2", "patch", "a1", `{"location":"west","area":"100"}`); err != nil {
+		t.Fatal(err)
+	}
+	locations := areaStats(t, mockStub, "location")
+	if len(locations) != 2 || locations[0].Group != "east" || locations[0].Area != "0.25" || locations[1].Count != 2 || locations[1].Area != "100" {
+		t.Fatal(locations)
+	}
+	if _, err := invoke(mockStub, "tx3", "getAreaStats", "district"); err == nil {
+		t.Fatal("unknown dimension should be rejected")
+	}
+}
+
+func TestRebuildAreaStats(t *testing.T) {
+	mockStub := shim.NewMockStub("asset", new(AssetFactory))
+	if _, err := invoke(mockStub, "tx1", "add", "a1", `{"id":"a1","account":"in","area":"10"}`); err != nil {
+		t.Fatal(err)
+	}
+	// 模拟统计出现偏差
+	_ = putAreaStats(mockStub, "account", "in", &areaTotals{count: 5, area: 1})
+	if _, err := invoke(mockStub, "tx2", "rebuildAreaStats"); err == nil {
+		t.Fatal("only admin can rebuild area stats")
+	}
+	admin := newCreator("Org1MSP", "admin", map[string]string{"admin": "true"})
+	if _, err := invokeAs(mockStub, admin, "tx3", "rebuildAreaStats"); err != nil {
+		t.Fatal(err)
+	}
+	if stats := areaStats(t, mockStub, "account"); len(stats) != 1 || stats[0].Count != 1 || stats[0].Area != "10" {
+		t.Fatal(stats)
+	}
+}
+
+func TestAreaStatsLegacyAsset(t *testing.T) {
+	mockStub := shim.NewMockStub("asset", new(AssetFactory))
+	if _, err := invoke(mockStub, "tx1", "add", "a1", `{"id":"a1","location":"east","area":"10"}`); err != nil {
+		t.Fatal(err)
+	}
+	// 模拟引入面积汇总之前写入的资产，没有计入汇总
+	mockStub.MockTransactionStart("tx2")
+	key, _ := assetKey(mockStub, "a2")
+	_ = mockStub.PutState(key, []byte(`{"id":"a2","location":"east","area":"20","owner":{"mspId":"Org1MSP","subject":"CN=user1,O=Org1MSP"}}`))
+	mockStub.MockTransactionEnd("tx2")
+
+	if _, err := invoke(mockStub, "tx3", "patch", "a2", `{"area":"25"}`); err != nil {
+		t.Fatal(err)
+	}
+	if stats := areaStats(t, mockStub, "location", "east"); len(stats) != 1 || stats[0].Count != 2 || stats[0].Area != "35" {
+		t.Fatal(stats)
+	}
+	// 计入汇总之后再修改时减去旧值
+	if _, err := invoke(mockStub, "tx4", "patch", "a2", `{"location":"west"}`); err != nil {
+		t.Fatal(err)
+	}
+	if stats := areaStats(t, mockStub, "location", "east"); len(stats) != 1 || stats[0].Count != 1 || stats[0].Area != "10" {
+		t.Fatal(stats)
+	}
+	if stats := areaStats(t, mockStub, "location", "west"); len(stats) != 1 || stats[0].Count != 1 || stats[0].Area != "25" {
+		t.Fatal(stats)
+	}
+}
+
+func TestAreaUnits(t *testing.T) {
+	valid := map[string]int64{"0": 0, "100.75": 1007500, "0.00005": 1, "0.00004": 0, "1000000000000": 10000000000000000, "0.1": 1000}
+	for value, expect := range valid {
+		if units, err := parseAreaUnits("area", value); err != nil || units != expect {
+			t.Fatal(value, units, err)
+		}
+	}
+	for _, value := range []string{"", "-1", "1e3", "abc", "1000000000000.0001", "NaN"} {
+		if _, err := parseAreaUnits("area", value); err == nil {
+			t.Fatal(value)
+		}
+	}
+	formats := map[int64]string{0: "0", 1007500: "100.75", 1: "0.0001", 3000000: "300", -2500: "-0.25", 123456789012345678: "12345678901234.5678"}
+	for units, expect := range formats {
+		if actual := formatAreaUnits(units); actual != expect || areaUnits(actual) != units {
+			t.Fatal(units, actual, expect)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// fabric 在同一交易内读不到刚写入的状态，txStub 记录本交易的写入，使 GetState 能读到，
+// 同一交易多次修改同一记录（如批量新增时的统计汇总）时不会互相覆盖；范围查询和富查询仍只能读到已提交的状态
+type txStub struct {
+	shim.ChaincodeStubInterface
+	writes    map[string][]byte
+	changeLog changeLog // 本交易修改的记录，交易成功后作为事件发送
+}
+
+func newTxStub(stub shim.ChaincodeStubInterface) *txStub {
+	return &txStub{ChaincodeStubInterface: stub, writes: make(map[string][]byte)}
+}
+
+func (stub *txStub) GetState(key string) ([]byte, error) {
+	if value, ok := stub.writes[key]; ok {
+		return value, nil
+	}
+	return stub.ChaincodeStubInterface.GetState(key)
+}
+
+func (stub *txStub) PutState(key string, value []byte) error {
+	err := stub.ChaincodeStubInterface.PutState(key, value)
+	if err != nil {
+		return err
+	}
+	stub.writes[key] = value
+	return nil
+}
+
+func (stub *txStub) DelState(key string) error {
+	err := stub.ChaincodeStubInterface.DelState(key)
+	if err != nil {
+		return err
+	}
+	stub.writes[key] = nil
+	return nil
+}
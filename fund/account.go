@@ -0,0 +1,449 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	accountObjectType   = "account"
+	statementObjectType = "statement"
+
+	EntryTypeDeposit     = "deposit"
+	EntryTypeWithdraw    = "withdraw"
+	EntryTypeTransferIn  = "transferIn"
+	EntryTypeTransferOut = "transferOut"
+)
+
+var (
+	currencyPattern    = regexp.MustCompile(`^[A-Z]{3}$`)
+	minorAmountPattern = regexp.MustCompile(`^[1-9][0-9]*$`)
+)
+
+type Owner struct {
+	MSPID   string `json:"mspId"`   // 所属组织
+	Subject string `json:"subject"` // 证书主题
+}
+
+type Account struct {
+	ID         string `json:"id"`         // 账户id
+	Owner      Owner  `json:"owner"`      // 开户人身份
+	Currency   string `json:"currency"`   // 币种，如 CNY
+	Balance    int64  `json:"balance"`    // 余额，最小货币单位（如分）
+	Seq        uint64 `json:"seq"`        // 最后一条流水的序号
+	Version    int64  `json:"version"`    // 版本，每次写入加 1
+	CreateTxId string `json:"createTxId"` // 开户交易
+	CreateTime string `json:"createTime"` // 开户时间
+}
+
+type StatementEntry struct {
+	AccountID    string `json:"accountId"`
+	Seq          uint64 `json:"seq"`                    // 流水序号，从 1 开始
+	Type         string `json:"type"`                   // deposit 存入，withdraw 取出，transferIn 转入，transferOut 转出
+	Amount       int64  `json:"amount"`                 // 发生额，最小货币单位
+	Balance      int64  `json:"balance"`                // 发生后余额
+	Counterparty string `json:"counterparty,omitempty"` // 转账对方账户
+	Memo         string `json:"memo,omitempty"`
+	TxId         string `json:"txId"`
+	Time         string `json:"time"`
+}
+
+type StatementQuery struct {
+	AccountID string `json:"accountId"`
+	Bookmark  string `json:"bookmark"`
+	PageSize  int32  `json:"pageSize"`
+}
+
+// 开户，调用者成为账户所有者
+// accountId string required
+// currency string required 三位大写字母，如 CNY
+func (t *Fund) openAccount(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 2 {
+		return errorResponse(CodeInvalidArgument, "should have 2 args")
+	}
+	accountId, currency := args[0], args[1]
+	if accountId == "" {
+		return errorResponse(CodeInvalidArgument, "accountId is required")
+	}
+	if !currencyPattern.MatchString(currency) {
+		return errorResponse(CodeInvalidArgument, "currency should be 3 uppercase letters, get "+currency)
+	}
+	existing, err := getAccount(stub, accountId)
+	if err != nil {
+		return errorResponse(CodeInternal, err.Error())
+	}
+	if existing != nil {
+		return errorResponse(CodeAlreadyExists, "account "+accountId+" already exists")
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return errorResponse(CodePermissionDenied, err.Error())
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return errorResponse(CodeInternal, err.Error())
+	}
+	account := Account{
+		ID:         accountId,
+		Owner:      *caller,
+		Currency:   currency,
+		CreateTxId: stub.GetTxID(),
+		CreateTime: txTime.Format(time.RFC3339),
+	}
+	err = putAccount(stub, &account)
+	if err != nil {
+		return errorResponse(CodeInternal, err.Error())
+	}
+	return shim.Success([]byte(accountId))
+}
+
+// 存入，需由 admin 或 teller 属性为 true 的身份（如柜员收取现金后）调用，账户所有者不能自行存入
+// accountId string required
+// amount string required 正整数，最小货币单位
+// memo string
+// expectedVersion string 可选，账户的当前版本，不一致时报错
+func (t *Fund) deposit(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) < 2 || len(args) > 4 {
+		return errorResponse(CodeInvalidArgument, "should have 2 to 4 args")
+	}
+	return changeBalance(stub, args, EntryTypeDeposit)
+}
+
+// 取出，需由 admin 或 teller 属性为 true 的身份（如柜员支付现金时）调用，余额不足时报错
+// 取出没有对应的入账方，账户所有者只能通过 transfer 转出
+// accountId string required
+// amount string required 正整数，最小货币单位
+// memo string
+// expectedVersion string 可选，账户的当前版本，不一致时报错
+func (t *Fund) withdraw(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) < 2 || len(args) > 4 {
+		return errorResponse(CodeInvalidArgument, "should have 2 to 4 args")
+	}
+	return changeBalance(stub, args, EntryTypeWithdraw)
+}
+
+func changeBalance(stub shim.ChaincodeStubInterface, args []string, entryType string) peer.Response {
+	accountId, amountStr := args[0], args[1]
+	memo, expectedVersion := "", ""
+	if len(args) > 2 {
+		memo = args[2]
+	}
+	if len(args) > 3 {
+		expectedVersion = args[3]
+	}
+	amount, err := parseMinorAmount(amountStr)
+	if err != nil {
+		return errorResponse(CodeInvalidArgument, err.Error())
+	}
+	// 存入和取出改变资金总量，只能由柜员操作，否则所有者可以凭空增加余额
+	err = checkTeller(stub)
+	if err != nil {
+		return errorResponse(CodePermissionDenied, err.Error())
+	}
+	account, err := getAccount(stub, accountId)
+	if err != nil {
+		return errorResponse(CodeInternal, err.Error())
+	}
+	if account == nil {
+		return errorResponse(CodeNotFound, "account "+accountId+" "+ErrorNotFound)
+	}
+	code, err := checkVersion(accountId, account.Version, expectedVersion)
+	if err != nil {
+		return errorResponse(code, err.Error())
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return errorResponse(CodeInternal, err.Error())
+	}
+	entry := StatementEntry{Type: entryType, Amount: amount, Memo: memo}
+	if entryType == EntryTypeWithdraw {
+		err = debit(account, amount)
+	} else {
+		err = credit(account, amount)
+	}
+	if err != nil {
+		return errorResponse(CodeFailedPrecondition, err.Error())
+	}
+	err = appendEntry(stub, account, &entry, txTime)
+	if err != nil {
+		return errorResponse(CodeInternal, err.Error())
+	}
+	return shim.Success([]byte(stub.GetTxID()))
+}
+
+// 转账，需由转出账户所有者调用，两个账户币种需一致，余额不足时报错
+// fromAccountId string required
+// toAccountId string required
+// amount string required 正整数，最小货币单位
+// memo string
+// expectedVersion string 可选，转出账户的当前版本，不一致时报错
+func (t *Fund) transfer(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) < 3 || len(args) > 5 {
+		return errorResponse(CodeInvalidArgument, "should have 3 to 5 args")
+	}
+	fromId, toId, amountStr := args[0], args[1], args[2]
+	memo, expectedVersion := "", ""
+	if len(args) > 3 {
+		memo = args[3]
+	}
+	if len(args) > 4 {
+		expectedVersion = args[4]
+	}
+	if fromId == toId {
+		return errorResponse(CodeInvalidArgument, "fromAccountId and toAccountId should be different")
+	}
+	amount, err := parseMinorAmount(amountStr)
+	if err != nil {
+		return errorResponse(CodeInvalidArgument, err.Error())
+	}
+	from, code, err := getOwnedAccount(stub, fromId)
+	if err != nil {
+		return errorResponse(code, err.Error())
+	}
+	code, err = checkVersion(fromId, from.Version, expectedVersion)
+	if err != nil {
+		return errorResponse(code, err.Error())
+	}
+	to, err := getAccount(stub, toId)
+	if err != nil {
+		return errorResponse(CodeInternal, err.Error())
+	}
+	if to == nil {
+		return errorResponse(CodeNotFound, "account "+toId+" "+ErrorNotFound)
+	}
+	if from.Currency != to.Currency {
+		return errorResponse(CodeFailedPrecondition, "currency of account "+fromId+" and "+toId+" should be the same")
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return errorResponse(CodeInternal, err.Error())
+	}
+	err = debit(from, amount)
+	if err != nil {
+		return errorResponse(CodeFailedPrecondition, err.Error())
+	}
+	err = credit(to, amount)
+	if err != nil {
+		return errorResponse(CodeFailedPrecondition, err.Error())
+	}
+	err = appendEntry(stub, from, &StatementEntry{Type: EntryTypeTransferOut, Amount: amount, Counterparty: toId, Memo: memo}, txTime)
+	if err != nil {
+		return errorResponse(CodeInternal, err.Error())
+	}
+	err = appendEntry(stub, to, &StatementEntry{Type: EntryTypeTransferIn, Amount: amount, Counterparty: fromId, Memo: memo}, txTime)
+	if err != nil {
+		return errorResponse(CodeInternal, err.Error())
+	}
+	return shim.Success([]byte(stub.GetTxID()))
+}
+
+// 查询账户余额
+// accountId string required
+// res : Account
+func (t *Fund) getBalance(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return errorResponse(CodeInvalidArgument, "should have 1 args")
+	}
+	account, err := getAccount(stub, args[0])
+	if err != nil {
+		return errorResponse(CodeInternal, err.Error())
+	}
+	if account == nil {
+		return errorResponse(CodeNotFound, ErrorNotFound)
+	}
+	res, err := json.Marshal(account)
+	if err != nil {
+		return errorResponse(CodeInternal, "failed to marshal account:"+err.Error())
+	}
+	return shim.Success(res)
+}
+
+// 分页查询账户流水，按发生顺序排列
+// accountId string required
+// bookmark string
+// pageSize int required
+// res : {data:[StatementEntry],"bookmark": "bookmark"}
+func (t *Fund) getStatement(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return errorResponse(CodeInvalidArgument, "should have 1 args")
+	}
+	argStruct := StatementQuery{}
+	err := json.Unmarshal([]byte(args[0]), &argStruct)
+	if err != nil {
+		return errorResponse(CodeInvalidArgument, "failed to unmarshal argStruct:"+err.Error())
+	}
+	if argStruct.AccountID == "" {
+		return errorResponse(CodeInvalidArgument, "accountId is required")
+	}
+	if argStruct.PageSize <= 0 {
+		return errorResponse(CodeInvalidArgument, "pageSize should be greater than 0")
+	}
+	resultsIterator, responseMetadata, err := stub.GetStateByPartialCompositeKeyWithPagination(statementObjectType, []string{argStruct.AccountID}, argStruct.PageSize, argStruct.Bookmark)
+	if err != nil {
+		return errorResponse(CodeInternal, err.Error())
+	}
+	defer resultsIterator.Close()
+
+	data := make([]StatementEntry, 0)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(CodeInternal, "failed get resultsIterator:"+err.Error())
+		}
+		entry := StatementEntry{}
+		err = json.Unmarshal(queryResponse.Value, &entry)
+		if err != nil {
+			return errorResponse(CodeInternal, "failed to unmarshal statement entry:"+err.Error())
+		}
+		data = append(data, entry)
+	}
+	res := map[string]interface{}{
+		"data":     data,
+		"bookmark": responseMetadata.Bookmark,
+	}
+	resStr, err := json.Marshal(&res)
+	if err != nil {
+		return errorResponse(CodeInternal, "failed to marshal res"+err.Error())
+	}
+	return shim.Success(resStr)
+}
+
+// 存入和取出需要 admin 或 teller 属性为 true
+func checkTeller(stub shim.ChaincodeStubInterface) error {
+	return checkAnyAttribute(stub, "admin", "teller")
+}
+
+// 获取账户并校验调用者为所有者，出错时同时返回错误码
+func getOwnedAccount(stub shim.ChaincodeStubInterface, accountId string) (*Account, string, error) {
+	account, err := getAccount(stub, accountId)
+	if err != nil {
+		return nil, CodeInternal, err
+	}
+	if account == nil {
+		return nil, CodeNotFound, fmt.Errorf("account %s %s", accountId, ErrorNotFound)
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return nil, CodePermissionDenied, err
+	}
+	if *caller != account.Owner {
+		return nil, CodePermissionDenied, fmt.Errorf("only the owner of account %s can operate it", accountId)
+	}
+	return account, "", nil
+}
+
+func debit(account *Account, amount int64) error {
+	if account.Balance < amount {
+		return fmt.Errorf("insufficient balance of account %s: %d < %d", account.ID, account.Balance, amount)
+	}
+	account.Balance -= amount
+	return nil
+}
+
+func credit(account *Account, amount int64) error {
+	if account.Balance > math.MaxInt64-amount {
+		return fmt.Errorf("balance of account %s overflows", account.ID)
+	}
+	account.Balance += amount
+	return nil
+}
+
+// 记录一条流水并保存账户，entry 中的序号、余额和交易信息由此处填写
+func appendEntry(stub shim.ChaincodeStubInterface, account *Account, entry *StatementEntry, txTime time.Time) error {
+	account.Seq++
+	entry.AccountID = account.ID
+	entry.Seq = account.Seq
+	entry.Balance = account.Balance
+	entry.TxId = stub.GetTxID()
+	entry.Time = txTime.Format(time.RFC3339)
+
+	// 序号补齐位数，保证按 key 排序即为发生顺序
+	key, err := stub.CreateCompositeKey(statementObjectType, []string{account.ID, fmt.Sprintf("%020d", entry.Seq)})
+	if err != nil {
+		return err
+	}
+	jsonVal, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal statement entry: %s", err.Error())
+	}
+	err = stub.PutState(key, jsonVal)
+	if err != nil {
+		return err
+	}
+	return putAccount(stub, account)
+}
+
+func parseMinorAmount(amountStr string) (int64, error) {
+	if !minorAmountPattern.MatchString(amountStr) {
+		return 0, fmt.Errorf("amount should be a positive integer in minor units, get %s", amountStr)
+	}
+	amount, err := strconv.ParseInt(amountStr, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("amount out of range: %s", amountStr)
+	}
+	return amount, nil
+}
+
+func getAccount(stub shim.ChaincodeStubInterface, accountId string) (*Account, error) {
+	key, err := stub.CreateCompositeKey(accountObjectType, []string{accountId})
+	if err != nil {
+		return nil, err
+	}
+	jsonVal, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if jsonVal == nil {
+		return nil, nil
+	}
+	account := Account{}
+	err = json.Unmarshal(jsonVal, &account)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal account %s: %s", accountId, err.Error())
+	}
+	return &account, nil
+}
+
+// 调用方持有读取时的账户，写入时版本加 1
+func putAccount(stub shim.ChaincodeStubInterface, account *Account) error {
+	account.Version++
+	key, err := stub.CreateCompositeKey(accountObjectType, []string{account.ID})
+	if err != nil {
+		return err
+	}
+	jsonVal, err := json.Marshal(account)
+	if err != nil {
+		return fmt.Errorf("failed to marshal account: %s", err.Error())
+	}
+	return putRecord(stub, accountObjectType, account.ID, key, jsonVal)
+}
+
+// 调用者身份，取自客户端证书
+func getCaller(stub shim.ChaincodeStubInterface) (*Owner, error) {
+	mspId, err := cid.GetMSPID(stub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller msp id: %s", err.Error())
+	}
+	cert, err := cid.GetX509Certificate(stub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller certificate: %s", err.Error())
+	}
+	return &Owner{MSPID: mspId, Subject: cert.Subject.String()}, nil
+}
+
+// 交易时间，作为链码中的 "当前时间"，各背书节点一致
+func getTxTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
@@ -1,15 +1,44 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	"github.com/hyperledger/fabric/protos/peer"
 )
 
+const (
+	CodeInvalidArgument    = "INVALID_ARGUMENT"
+	CodeNotFound           = "NOT_FOUND"
+	CodeAlreadyExists      = "ALREADY_EXISTS"
+	CodePermissionDenied   = "PERMISSION_DENIED"
+	CodeFailedPrecondition = "FAILED_PRECONDITION"
+	CodeAborted            = "ABORTED" // 版本冲突
+	CodeInternal           = "INTERNAL"
+)
+
+var ErrorNotFound = fmt.Sprint("record not found")
+
+// 资金记录以组合键 fundBill[id] 存储，避免与其他类型的记录冲突
+const fundBillObjectType = "fundBill"
+
 type Fund struct {
 }
 
+type Pagination struct {
+	Bookmark string `json:"bookmark"`
+	PageSize int32  `json:"pageSize"`
+}
+
+type ErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
 type FundBill struct {
 	ID string `json:"id,omitempty"` //资产id
 	District string `json:"district,omitempty"` //所在位置
@@ -32,6 +61,7 @@ type FundBill struct {
 	Longitude string `json:"longitude"`//经度
 	Latitude string `json:"latitude"`//纬度
 	Remark string `json:"remark,omitempty"`//备注
+	Version int64 `json:"version"`//版本，每次写入加 1，不需要传入
 }
 
 // Init is called during chaincode instantiation to initialize any
@@ -42,8 +72,22 @@ func (t *Fund) Init(stub shim.ChaincodeStubInterface) peer.Response {
 }
 
 func (t *Fund) Invoke(stub shim.ChaincodeStubInterface) peer.Response {
+	es := &eventStub{ChaincodeStubInterface: stub}
 	// Extract the function and args from the transaction proposal
-	fn, args := stub.GetFunctionAndParameters()
+	fn, args := es.GetFunctionAndParameters()
+	res := t.invoke(es, fn, args)
+	if res.Status >= shim.ERRORTHRESHOLD {
+		return res
+	}
+	// 交易中修改的记录合并为一个事件
+	err := es.changeLog.emit(es, fn)
+	if err != nil {
+		return errorResponse(CodeInternal, err.Error())
+	}
+	return res
+}
+
+func (t *Fund) invoke(stub shim.ChaincodeStubInterface, fn string, args []string) peer.Response {
 	switch fn {
 	case "add":
 		return t.add(stub, args)
@@ -51,38 +95,363 @@ func (t *Fund) Invoke(stub shim.ChaincodeStubInterface) peer.Response {
 		return t.getById(stub, args)
 	case "update":
 		return t.update(stub, args)
+	case "patch":
+		return t.patch(stub, args)
+	case "list":
+		return t.list(stub, args)
+	case "migrateKeys":
+		return t.migrateKeys(stub, args)
+	case "openAccount":
+		return t.openAccount(stub, args)
+	case "deposit":
+		return t.deposit(stub, args)
+	case "withdraw":
+		return t.withdraw(stub, args)
+	case "transfer":
+		return t.transfer(stub, args)
+	case "getBalance":
+		return t.getBalance(stub, args)
+	case "getStatement":
+		return t.getStatement(stub, args)
+	case "addLedgerAccount":
+		return t.addLedgerAccount(stub, args)
+	case "getChartOfAccounts":
+		return t.getChartOfAccounts(stub, args)
+	case "postJournalEntry":
+		return t.postJournalEntry(stub, args)
+	case "getJournalEntry":
+		return t.getJournalEntry(stub, args)
+	case "getTrialBalance":
+		return t.getTrialBalance(stub, args)
+	case "getLedger":
+		return t.getLedger(stub, args)
 	default:
-		return shim.Error("unsupported method " + fn)
+		return errorResponse(CodeInvalidArgument, "unsupported method "+fn)
 	}
 }
 
+// 新增资金记录，id 已存在时报错
+// id string required
+// value string required FundBill json，id 需与第一个参数一致
 func (t *Fund) add(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 2 {
+		return errorResponse(CodeInvalidArgument, "should have 2 args")
+	}
 	id, jsonValue := args[0], args[1]
-	err := stub.PutState(id, []byte(jsonValue))
+	bill, err := decodeFundBill(id, jsonValue)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(CodeInvalidArgument, err.Error())
+	}
+	existing, err := getFundBill(stub, id)
+	if err != nil {
+		return errorResponse(CodeInternal, err.Error())
+	}
+	if existing != nil {
+		return errorResponse(CodeAlreadyExists, "fund bill "+id+" already exists")
+	}
+	err = putFundBill(stub, bill)
+	if err != nil {
+		return errorResponse(CodeInternal, err.Error())
 	}
 	return shim.Success([]byte(id))
 }
 
+// 根据 id 获取资金记录
+// id string required
 func (t *Fund) getById(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return errorResponse(CodeInvalidArgument, "should have 1 args")
+	}
 	id := args[0]
-	jsonValue, err := stub.GetState(id)
+	if id == "" {
+		return errorResponse(CodeInvalidArgument, "id is required")
+	}
+	key, err := fundBillKey(stub, id)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(CodeInvalidArgument, err.Error())
+	}
+	jsonValue, err := stub.GetState(key)
+	if err != nil {
+		return errorResponse(CodeInternal, err.Error())
+	}
+	if jsonValue == nil {
+		return errorResponse(CodeNotFound, ErrorNotFound)
 	}
 	return shim.Success(jsonValue)
 }
 
+// 按 id 顺序分页列出全部资金记录
+// bookmark string
+// pageSize int required
+// res : {data:[FundBill],"bookmark": "bookmark"}
+func (t *Fund) list(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return errorResponse(CodeInvalidArgument, "should have 1 args")
+	}
+	argStruct := Pagination{}
+	err := json.Unmarshal([]byte(args[0]), &argStruct)
+	if err != nil {
+		return errorResponse(CodeInvalidArgument, "failed to unmarshal argStruct:"+err.Error())
+	}
+	if argStruct.PageSize <= 0 {
+		return errorResponse(CodeInvalidArgument, "pageSize should be greater than 0")
+	}
+	resultsIterator, responseMetadata, err := stub.GetStateByPartialCompositeKeyWithPagination(fundBillObjectType, []string{}, argStruct.PageSize, argStruct.Bookmark)
+	if err != nil {
+		return errorResponse(CodeInternal, err.Error())
+	}
+	defer resultsIterator.Close()
+
+	data := make([]FundBill, 0)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(CodeInternal, "failed get resultsIterator:"+err.Error())
+		}
+		bill := FundBill{}
+		err = json.Unmarshal(queryResponse.Value, &bill)
+		if err != nil {
+			return errorResponse(CodeInternal, "failed to unmarshal fund bill:"+err.Error())
+		}
+		data = append(data, bill)
+	}
+	res := map[string]interface{}{
+		"data":     data,
+		"bookmark": responseMetadata.Bookmark,
+	}
+	resStr, err := json.Marshal(&res)
+	if err != nil {
+		return errorResponse(CodeInternal, "failed to marshal res"+err.Error())
+	}
+	return shim.Success(resStr)
+}
+
+// 全量更新资金记录，id 不存在时报错
+// id string required
+// value string required FundBill json，id 需与第一个参数一致
+// expectedVersion string 可选，与当前版本不一致时报错
 func (t *Fund) update(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 2 && len(args) != 3 {
+		return errorResponse(CodeInvalidArgument, "should have 2 or 3 args")
+	}
 	id, jsonValue := args[0], args[1]
-	err := stub.PutState(id, []byte(jsonValue))
+	expectedVersion := ""
+	if len(args) == 3 {
+		expectedVersion = args[2]
+	}
+	bill, err := decodeFundBill(id, jsonValue)
+	if err != nil {
+		return errorResponse(CodeInvalidArgument, err.Error())
+	}
+	existing, err := getFundBill(stub, id)
+	if err != nil {
+		return errorResponse(CodeInternal, err.Error())
+	}
+	if existing == nil {
+		return errorResponse(CodeNotFound, ErrorNotFound)
+	}
+	code, err := checkVersion(id, existing.Version, expectedVersion)
+	if err != nil {
+		return errorResponse(code, err.Error())
+	}
+	err = putFundBill(stub, bill)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(CodeInternal, err.Error())
 	}
 	return shim.Success([]byte(id))
 }
 
+// 部分更新资金记录，只修改 patch 中出现的字段
+// id string required
+// patch string required {"jsonTag": "value"}
+// expectedVersion string 可选，与当前版本不一致时报错
+func (t *Fund) patch(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 2 && len(args) != 3 {
+		return errorResponse(CodeInvalidArgument, "should have 2 or 3 args")
+	}
+	id, patchValue := args[0], args[1]
+	expectedVersion := ""
+	if len(args) == 3 {
+		expectedVersion = args[2]
+	}
+	bill, err := getFundBill(stub, id)
+	if err != nil {
+		return errorResponse(CodeInternal, err.Error())
+	}
+	if bill == nil {
+		return errorResponse(CodeNotFound, ErrorNotFound)
+	}
+	code, err := checkVersion(id, bill.Version, expectedVersion)
+	if err != nil {
+		return errorResponse(code, err.Error())
+	}
+	patchMap, err := decodeFundBillPatch(id, patchValue)
+	if err != nil {
+		return errorResponse(CodeInvalidArgument, err.Error())
+	}
+	update := mergeStructAndMap(bill, patchMap).(*FundBill)
+	err = putFundBill(stub, update)
+	if err != nil {
+		return errorResponse(CodeInternal, err.Error())
+	}
+	return shim.Success([]byte(stub.GetTxID()))
+}
+
+// 严格解析 FundBill json，不允许未知字段，且 id 需与 key 一致
+func decodeFundBill(id string, jsonValue string) (*FundBill, error) {
+	if id == "" {
+		return nil, fmt.Errorf("id is required")
+	}
+	bill := FundBill{}
+	decoder := json.NewDecoder(strings.NewReader(jsonValue))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&bill)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fund bill: %s", err.Error())
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("invalid fund bill: unexpected data after json object")
+	}
+	if bill.ID != id {
+		return nil, fmt.Errorf("id in value should be %s, get %s", id, bill.ID)
+	}
+	return &bill, nil
+}
+
+// 严格解析 patch，字段需为 FundBill 的 json 字段且值为字符串，与 add、update 一致不允许未知字段
+func decodeFundBillPatch(id string, patchValue string) (map[string]string, error) {
+	rawMap := make(map[string]interface{})
+	decoder := json.NewDecoder(strings.NewReader(patchValue))
+	err := decoder.Decode(&rawMap)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal patch: %s", err.Error())
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("invalid patch: unexpected data after json object")
+	}
+	if _, ok := rawMap["version"]; ok {
+		return nil, fmt.Errorf("version is maintained by the chaincode, pass expectedVersion instead")
+	}
+	fields := fundBillStringFields()
+	patchMap := make(map[string]string)
+	for field, value := range rawMap {
+		if !fields[field] {
+			return nil, fmt.Errorf("unknown field %s in patch", field)
+		}
+		str, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("value of %s should be a string", field)
+		}
+		patchMap[field] = str
+	}
+	if patchId, ok := patchMap["id"]; ok && patchId != id {
+		return nil, fmt.Errorf("id in patch should be %s, get %s", id, patchId)
+	}
+	return patchMap, nil
+}
+
+// FundBill 中可通过 patch 修改的字符串字段，按 json 字段名
+func fundBillStringFields() map[string]bool {
+	fields := make(map[string]bool)
+	billType := reflect.TypeOf(FundBill{})
+	for i := 0; i < billType.NumField(); i++ {
+		field := billType.Field(i)
+		if field.Type.Kind() == reflect.String {
+			fields[strings.Split(field.Tag.Get("json"), ",")[0]] = true
+		}
+	}
+	return fields
+}
+
+func fundBillKey(stub shim.ChaincodeStubInterface, id string) (string, error) {
+	if id == "" {
+		return "", fmt.Errorf("id is required")
+	}
+	return stub.CreateCompositeKey(fundBillObjectType, []string{id})
+}
+
+// 根据 id 获取资金记录，不存在时返回 nil
+func getFundBill(stub shim.ChaincodeStubInterface, id string) (*FundBill, error) {
+	key, err := fundBillKey(stub, id)
+	if err != nil {
+		return nil, err
+	}
+	jsonVal, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if jsonVal == nil {
+		return nil, nil
+	}
+	bill := FundBill{}
+	err = json.Unmarshal(jsonVal, &bill)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal fund bill %s: %s", id, err.Error())
+	}
+	return &bill, nil
+}
+
+// 按 FundBill 结构重新序列化后写入，版本在上一版本的基础上加 1
+func putFundBill(stub shim.ChaincodeStubInterface, bill *FundBill) error {
+	previous, err := getFundBill(stub, bill.ID)
+	if err != nil {
+		return err
+	}
+	bill.Version = 1
+	if previous != nil {
+		bill.Version = previous.Version + 1
+	}
+	key, err := fundBillKey(stub, bill.ID)
+	if err != nil {
+		return err
+	}
+	jsonVal, err := json.Marshal(bill)
+	if err != nil {
+		return fmt.Errorf("failed to marshal fund bill: %s", err.Error())
+	}
+	return putRecord(stub, fundBillObjectType, bill.ID, key, jsonVal)
+}
+
+// 校验调用方传入的 expectedVersion，为空时不校验，出错时同时返回错误码；引入版本之前写入的记录版本为 0
+func checkVersion(id string, version int64, expectedVersion string) (string, error) {
+	if expectedVersion == "" {
+		return "", nil
+	}
+	expected, err := strconv.ParseInt(expectedVersion, 10, 64)
+	if err != nil {
+		return CodeInvalidArgument, fmt.Errorf("expectedVersion should be an integer, get %s", expectedVersion)
+	}
+	if expected != version {
+		return CodeAborted, fmt.Errorf("version conflict: %s is at version %d, expected %d", id, version, expected)
+	}
+	return "", nil
+}
+
+// 以 json 格式返回错误信息 {"code": "...", "message": "..."}
+func errorResponse(code string, message string) peer.Response {
+	res, err := json.Marshal(&ErrorResponse{Code: code, Message: message})
+	if err != nil {
+		return shim.Error(message)
+	}
+	return shim.Error(string(res))
+}
+
+func mergeStructAndMap(point interface{}, jsonMap map[string]string) interface{} {
+	orderType := reflect.TypeOf(point).Elem()
+	orderValue := reflect.ValueOf(point).Elem()
+	for i := 0; i < orderType.NumField(); i++ {
+		field := orderType.Field(i)
+		jsonTag := strings.Split(field.Tag.Get("json"), ",")[0]
+		if field.Type.Kind() != reflect.String {
+			continue
+		}
+		if val, ok := jsonMap[jsonTag]; ok {
+			orderValue.FieldByName(field.Name).SetString(val)
+		}
+	}
+	return point
+}
+
 // main function starts up the chaincode in the container during instantiate
 func main() {
 	if err := shim.Start(new(Fund)); err != nil {
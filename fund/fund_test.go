@@ -0,0 +1,397 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/msp"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// MockStub 不支持 GetCreator，用 identityStub 模拟调用者身份
+type identityStub struct {
+	*shim.MockStub
+	args    []string
+	creator []byte
+}
+
+func (stub *identityStub) GetFunctionAndParameters() (string, []string) {
+	return stub.args[0], stub.args[1:]
+}
+
+func (stub *identityStub) GetCreator() ([]byte, error) {
+	return stub.creator, nil
+}
+
+// 生成自签名证书作为调用者身份
+func newCreator(mspId string, commonName string, attrs map[string]string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{mspId}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if attrs != nil {
+		value, _ := json.Marshal(map[string]interface{}{"attrs": attrs})
+		template.ExtraExtensions = []pkix.Extension{{Id: asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}, Value: value}}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	creator, err := proto.Marshal(&msp.SerializedIdentity{
+		Mspid:   mspId,
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	})
+	if err != nil {
+		panic(err)
+	}
+	return creator
+}
+
+func invokeAs(mockStub *shim.MockStub, creator []byte, txId string, args ...string) peer.Response {
+	mockStub.MockTransactionStart(txId)
+	defer mockStub.MockTransactionEnd(txId)
+	return new(Fund).Invoke(&identityStub{MockStub: mockStub, args: args, creator: creator})
+}
+
+func TestAddAndPatch(t *testing.T) {
+	mockStub := shim.NewMockStub("fund", new(Fund))
+	res := mockStub.MockInvoke("tx1", [][]byte{[]byte("add"), []byte("1"), []byte(`{"id":"1","assetName":"name"}`)})
+	if res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	res = mockStub.MockInvoke("tx2", [][]byte{[]byte("add"), []byte("1"), []byte(`{"id":"1"}`)})
+	if res.Status == shim.OK {
+		t.Fatal("duplicated id should be rejected")
+	}
+	res = mockStub.MockInvoke("tx3", [][]byte{[]byte("patch"), []byte("1"), []byte(`{"remark":"remark"}`)})
+	if res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	res = mockStub.MockInvoke("tx4", [][]byte{[]byte("getById"), []byte("1")})
+	bill := FundBill{}
+	if err := json.Unmarshal(res.Payload, &bill); err != nil {
+		t.Fatal(err)
+	}
+	if bill.AssetName != "name" || bill.Remark != "remark" {
+		t.Fatal(bill)
+	}
+}
+
+func TestDecodeFundBillPatch(t *testing.T) {
+	cases := []struct {
+		patch string
+		ok    bool
+	}{
+		{`{"remark":"remark","is_mortage":"1"}`, true},
+		{`{"id":"1"}`, true},
+		{`{"id":"2"}`, false},
+		{`{"remak":"remark"}`, false},
+		{`{"remark":1}`, false},
+		{`{"remark":null}`, false},
+		{`{"version":"5"}`, false},
+		{`{} {}`, false},
+	}
+	for _, c := range cases {
+		if _, err := decodeFundBillPatch("1", c.patch); (err == nil) != c.ok {
+			t.Fatal(c.patch, err)
+		}
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	mockStub := shim.NewMockStub("fund", new(Fund))
+	res := mockStub.MockInvoke("tx1", [][]byte{[]byte("update"), []byte("1")})
+	errRes := ErrorResponse{}
+	if err := json.Unmarshal([]byte(res.Message), &errRes); err != nil {
+		t.Fatal(err)
+	}
+	if errRes.Code != CodeInvalidArgument {
+		t.Fatal(errRes)
+	}
+}
+
+func TestAccount(t *testing.T) {
+	mockStub := shim.NewMockStub("fund", new(Fund))
+	alice, bob := newCreator("Org1MSP", "alice", nil), newCreator("Org2MSP", "bob", nil)
+	teller := newCreator("Org1MSP", "teller", map[string]string{"teller": "true"})
+	if res := invokeAs(mockStub, alice, "tx1", "openAccount", "alice", "CNY"); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if res := invokeAs(mockStub, bob, "tx2", "openAccount", "bob", "CNY"); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	res := invokeAs(mockStub, alice, "tx3", "deposit", "alice", "10000")
+	errRes := ErrorResponse{}
+	if err := json.Unmarshal([]byte(res.Message), &errRes); err != nil || errRes.Code != CodePermissionDenied {
+		t.Fatal("owner should not deposit into the account by itself: " + res.Message)
+	}
+	if res := invokeAs(mockStub, teller, "tx3", "deposit", "alice", "10000", "salary"); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if res := invokeAs(mockStub, teller, "tx4", "deposit", "alice", "1.5"); res.Status == shim.OK {
+		t.Fatal("amount should be an integer in minor units")
+	}
+	if res := invokeAs(mockStub, alice, "tx5", "withdraw", "alice", "100"); res.Status == shim.OK {
+		t.Fatal("only teller can withdraw")
+	}
+	if res := invokeAs(mockStub, alice, "tx6", "transfer", "alice", "bob", "10001"); res.Status == shim.OK {
+		t.Fatal("overdraft should be rejected")
+	}
+	if res := invokeAs(mockStub, alice, "tx7", "transfer", "alice", "bob", "2500"); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if res := invokeAs(mockStub, teller, "tx8", "withdraw", "bob", "500"); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+
+	balances := map[string]int64{"alice": 7500, "bob": 2000}
+	for accountId, balance := range balances {
+		res := invokeAs(mockStub, alice, "tx9", "getBalance", accountId)
+		account := Account{}
+		if err := json.Unmarshal(res.Payload, &account); err != nil {
+			t.Fatal(err)
+		}
+		if account.Balance != balance || account.Seq != 2 {
+			t.Fatal(account)
+		}
+	}
+}
+
+func TestJournal(t *testing.T) {
+	mockStub := shim.NewMockStub("fund", new(Fund))
+	accountant := newCreator("Org1MSP", "accountant", map[string]string{"accountant": "true"})
+	chart := []string{
+		`{"code":"1001","name":"库存现金","type":"asset"}`,
+		`{"code":"2001","name":"短期借款","type":"liability"}`,
+		`{"code":"6001","name":"主营业务收入","type":"income"}`,
+	}
+	clerk := newCreator("Org1MSP", "clerk", nil)
+	res := invokeAs(mockStub, clerk, "chart-denied", "addLedgerAccount", chart[0])
+	errRes := ErrorResponse{}
+	if err := json.Unmarshal([]byte(res.Message), &errRes); err != nil || errRes.Code != CodePermissionDenied {
+		t.Fatal(res.Message)
+	}
+	for i, account := range chart {
+		if res := invokeAs(mockStub, accountant, fmt.Sprintf("chart%d", i), "addLedgerAccount", account); res.Status != shim.OK {
+			t.Fatal(res.Message)
+		}
+	}
+
+	rejected := map[string]string{
+		"unbalanced":      `{"id":"v1","date":"2021-01-01","lines":[{"accountCode":"1001","debit":100},{"accountCode":"6001","credit":90}]}`,
+		"single line":     `{"id":"v1","date":"2021-01-01","lines":[{"accountCode":"1001","debit":100}]}`,
+		"both sides":      `{"id":"v1","date":"2021-01-01","lines":[{"accountCode":"1001","debit":100,"credit":100},{"accountCode":"6001","credit":0}]}`,
+		"unknown account": `{"id":"v1","date":"2021-01-01","lines":[{"accountCode":"1001","debit":100},{"accountCode":"9999","credit":100}]}`,
+	}
+	for name, entry := range rejected {
+		if res := invokeAs(mockStub, accountant, "bad-"+name, "postJournalEntry", entry); res.Status == shim.OK {
+			t.Fatal(name + " entry should be rejected")
+		}
+	}
+
+	entries := []string{
+		`{"id":"v1","date":"2021-01-01","lines":[{"accountCode":"1001","debit":10000},{"accountCode":"2001","credit":10000}]}`,
+		`{"id":"v2","date":"2021-01-02","lines":[{"accountCode":"1001","debit":300},{"accountCode":"1001","debit":200},{"accountCode":"6001","credit":500}]}`,
+	}
+	for i, entry := range entries {
+		if res := invokeAs(mockStub, accountant, fmt.Sprintf("post%d", i), "postJournalEntry", entry); res.Status != shim.OK {
+			t.Fatal(res.Message)
+		}
+	}
+	if res := invokeAs(mockStub, accountant, "post-again", "postJournalEntry", entries[0]); res.Status == shim.OK {
+		t.Fatal("duplicated journal entry should be rejected")
+	}
+	res = invokeAs(mockStub, clerk, "post-denied", "postJournalEntry", `{"id":"v3","date":"2021-01-03","lines":[{"accountCode":"1001","debit":1},{"accountCode":"6001","credit":1}]}`)
+	if err := json.Unmarshal([]byte(res.Message), &errRes); err != nil || errRes.Code != CodePermissionDenied {
+		t.Fatal(res.Message)
+	}
+
+	res = invokeAs(mockStub, accountant, "tb", "getTrialBalance")
+	trialBalance := TrialBalance{}
+	if err := json.Unmarshal(res.Payload, &trialBalance); err != nil {
+		t.Fatal(err)
+	}
+	if !trialBalance.Balanced || trialBalance.DebitTotal != 10500 || len(trialBalance.Rows) != 3 {
+		t.Fatal(trialBalance)
+	}
+	if cash := trialBalance.Rows[0]; cash.Code != "1001" || cash.Debit != 10500 || cash.DebitTotal != 10500 {
+		t.Fatal(cash)
+	}
+
+	res = invokeAs(mockStub, accountant, "chart", "getChartOfAccounts")
+	accounts := make([]LedgerAccount, 0)
+	if err := json.Unmarshal(res.Payload, &accounts); err != nil {
+		t.Fatal(err)
+	}
+	if accounts[0].Seq != 3 || ledgerBalance(&accounts[1]) != 10000 {
+		t.Fatal(accounts)
+	}
+}
+
+// 同一凭证在不同背书节点上生成的事件需完全一致
+func TestJournalEventDeterministic(t *testing.T) {
+	accountant := newCreator("Org1MSP", "accountant", map[string]string{"accountant": "true"})
+	codes := []string{"1001", "1002", "1003", "2001", "2002", "6001"}
+	entry := `{"id":"v1","date":"2021-01-01","lines":[` +
+		`{"accountCode":"1001","debit":100},{"accountCode":"1002","debit":100},{"accountCode":"1003","debit":100},` +
+		`{"accountCode":"2001","credit":100},{"accountCode":"2002","credit":100},{"accountCode":"6001","credit":100}]}`
+	post := func() []byte {
+		mockStub := shim.NewMockStub("fund", new(Fund))
+		for i, code := range codes {
+			account := `{"code":"` + code + `","name":"` + code + `","type":"asset"}`
+			if res := invokeAs(mockStub, accountant, fmt.Sprintf("chart%d", i), "addLedgerAccount", account); res.Status != shim.OK {
+				t.Fatal(res.Message)
+			}
+			<-mockStub.ChaincodeEventsChannel
+		}
+		mockStub.MockTransactionStart("post")
+		mockStub.TxTimestamp.Seconds, mockStub.TxTimestamp.Nanos = 1609459200, 0
+		res := new(Fund).Invoke(&identityStub{MockStub: mockStub, args: []string{"postJournalEntry", entry}, creator: accountant})
+		mockStub.MockTransactionEnd("post")
+		if res.Status != shim.OK {
+			t.Fatal(res.Message)
+		}
+		return (<-mockStub.ChaincodeEventsChannel).Payload
+	}
+	expected := post()
+	for i := 0; i < 5; i++ {
+		if payload := post(); string(payload) != string(expected) {
+			t.Fatal(string(payload), string(expected))
+		}
+	}
+}
+
+func TestExpectedVersion(t *testing.T) {
+	mockStub := shim.NewMockStub("fund", new(Fund))
+	alice := newCreator("Org1MSP", "alice", nil)
+	if res := invokeAs(mockStub, alice, "tx1", "add", "1", `{"id":"1","district":"d1"}`); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if res := invokeAs(mockStub, alice, "tx2", "patch", "1", `{"district":"d2"}`, "1"); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	res := invokeAs(mockStub, alice, "tx3", "update", "1", `{"id":"1","district":"d3"}`, "1")
+	errRes := ErrorResponse{}
+	if err := json.Unmarshal([]byte(res.Message), &errRes); err != nil || errRes.Code != CodeAborted {
+		t.Fatal(res.Message)
+	}
+	if res := invokeAs(mockStub, alice, "tx4", "patch", "1", `{"version":"5"}`); res.Status == shim.OK {
+		t.Fatal("version should not be patched")
+	}
+
+	if res := invokeAs(mockStub, alice, "tx5", "openAccount", "alice", "CNY"); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	teller := newCreator("Org1MSP", "teller", map[string]string{"teller": "true"})
+	if res := invokeAs(mockStub, teller, "tx6", "deposit", "alice", "100", "", "1"); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if res := invokeAs(mockStub, teller, "tx7", "withdraw", "alice", "50", "", "1"); res.Status == shim.OK {
+		t.Fatal("stale withdraw should be rejected")
+	}
+	account, _ := getAccount(mockStub, "alice")
+	if account.Version != 2 || account.Balance != 100 {
+		t.Fatal(account)
+	}
+}
+
+func TestMigrateKeys(t *testing.T) {
+	mockStub := shim.NewMockStub("fund", new(Fund))
+	mockStub.MockTransactionStart("legacy")
+	_ = mockStub.PutState("1", []byte(`{"id":"1","assetName":"legacy","version":2}`))
+	_ = mockStub.PutState("2", []byte(`not json`))
+	_ = mockStub.PutState("3", []byte(`{"id":"4"}`))
+	_ = mockStub.PutState("4", []byte(`{"id":"4"}`))
+	mockStub.MockTransactionEnd("legacy")
+
+	res := invokeAs(mockStub, newCreator("Org1MSP", "user", nil), "tx1", "migrateKeys")
+	if res.Status == shim.OK {
+		t.Fatal("only admin can migrate keys")
+	}
+	admin := newCreator("Org1MSP", "admin", map[string]string{"admin": "true"})
+	migrate := func(txId string, args ...string) MigrateResult {
+		res := invokeAs(mockStub, admin, txId, append([]string{"migrateKeys"}, args...)...)
+		result := MigrateResult{}
+		if err := json.Unmarshal(res.Payload, &result); res.Status != shim.OK || err != nil {
+			t.Fatal(res.Message, string(res.Payload))
+		}
+		return result
+	}
+
+	// 跳过的记录计入 limit，下一批从 nextKey 继续
+	result := migrate("tx2", "2")
+	if result.Migrated != 1 || len(result.Failed) != 1 || result.Failed[0].Key != "2" || result.NextKey != "3" {
+		t.Fatal(result)
+	}
+	result = migrate("tx3", "", result.NextKey)
+	if result.Migrated != 1 || len(result.Failed) != 1 || result.Failed[0].Code != CodeFailedPrecondition || result.NextKey != "" {
+		t.Fatal(result)
+	}
+	if value, _ := mockStub.GetState("1"); value != nil {
+		t.Fatal("legacy key should be deleted")
+	}
+	res = mockStub.MockInvoke("tx4", [][]byte{[]byte("getById"), []byte("1")})
+	bill := FundBill{}
+	_ = json.Unmarshal(res.Payload, &bill)
+	if bill.AssetName != "legacy" || bill.Version != 2 {
+		t.Fatal(string(res.Payload))
+	}
+
+	// 失败的记录仍在原 key 下，修复前再次迁移依旧跳过
+	if result = migrate("tx5"); result.Migrated != 0 || len(result.Failed) != 2 {
+		t.Fatal(result)
+	}
+}
+
+func TestChangeEvents(t *testing.T) {
+	mockStub := shim.NewMockStub("fund", new(Fund))
+	alice := newCreator("Org1MSP", "alice", map[string]string{"teller": "true"})
+	for i, args := range [][]string{{"openAccount", "a", "CNY"}, {"openAccount", "b", "CNY"}, {"deposit", "a", "100"}} {
+		if res := invokeAs(mockStub, alice, fmt.Sprint("open", i), args...); res.Status != shim.OK {
+			t.Fatal(res.Message)
+		}
+		<-mockStub.ChaincodeEventsChannel
+	}
+	if res := invokeAs(mockStub, alice, "tx1", "transfer", "a", "b", "30"); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	event := <-mockStub.ChaincodeEventsChannel
+	batch := BatchChangeEvent{}
+	_ = json.Unmarshal(event.Payload, &batch)
+	if event.EventName != recordsChangedEvent || batch.TxId != "tx1" || len(batch.Events) != 2 {
+		t.Fatal(string(event.Payload))
+	}
+	for _, change := range batch.Events {
+		if change.RecordType != "account" || change.Operation != "transfer" {
+			t.Fatal(change)
+		}
+	}
+
+	if res := invokeAs(mockStub, alice, "tx2", "add", "1", `{"id":"1"}`); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	event = <-mockStub.ChaincodeEventsChannel
+	change := ChangeEvent{}
+	_ = json.Unmarshal(event.Payload, &change)
+	if event.EventName != recordChangedEvent || change.RecordType != "fundBill" || change.ID != "1" || change.Operation != "add" {
+		t.Fatal(string(event.Payload))
+	}
+}
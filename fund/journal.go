@@ -0,0 +1,537 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	ledgerAccountObjectType = "ledgerAccount"
+	journalEntryObjectType  = "journalEntry"
+	ledgerLineObjectType    = "ledgerLine"
+
+	// 科目类别，资产和费用类借方为正常余额方向，其余为贷方
+	LedgerTypeAsset     = "asset"
+	LedgerTypeLiability = "liability"
+	LedgerTypeEquity    = "equity"
+	LedgerTypeIncome    = "income"
+	LedgerTypeExpense   = "expense"
+
+	dateLayout = "2006-01-02"
+)
+
+var ledgerCodePattern = regexp.MustCompile(`^[0-9A-Za-z.\-]+$`)
+
+type LedgerAccount struct {
+	Code        string `json:"code"`        // 科目代码
+	Name        string `json:"name"`        // 科目名称
+	Type        string `json:"type"`        // asset 资产，liability 负债，equity 权益，income 收入，expense 费用
+	DebitTotal  int64  `json:"debitTotal"`  // 借方累计发生额，最小货币单位
+	CreditTotal int64  `json:"creditTotal"` // 贷方累计发生额，最小货币单位
+	Seq         uint64 `json:"seq"`         // 最后一条明细账的序号
+	Version     int64  `json:"version"`     // 版本，每次写入加 1
+}
+
+type JournalLine struct {
+	AccountCode string `json:"accountCode"`    // 科目代码
+	Debit       int64  `json:"debit"`          // 借方金额，与贷方金额有且只有一个大于 0
+	Credit      int64  `json:"credit"`         // 贷方金额
+	Memo        string `json:"memo,omitempty"` // 摘要
+}
+
+type JournalEntry struct {
+	ID       string        `json:"id"`             // 凭证号
+	Date     string        `json:"date"`           // 记账日期 yyyy-MM-dd
+	Memo     string        `json:"memo,omitempty"` // 摘要
+	Lines    []JournalLine `json:"lines"`          // 分录，借贷合计需相等
+	PostedBy *Owner        `json:"postedBy"`       // 过账人
+	PostTxId string        `json:"postTxId"`       // 过账交易
+	PostTime string        `json:"postTime"`       // 过账时间
+}
+
+type LedgerLine struct {
+	AccountCode string `json:"accountCode"`
+	Seq         uint64 `json:"seq"`     // 明细序号，从 1 开始
+	EntryID     string `json:"entryId"` // 凭证号
+	Date        string `json:"date"`
+	Debit       int64  `json:"debit"`
+	Credit      int64  `json:"credit"`
+	Balance     int64  `json:"balance"` // 发生后按科目正常方向计算的余额
+	Memo        string `json:"memo,omitempty"`
+	TxId        string `json:"txId"`
+}
+
+type TrialBalanceRow struct {
+	Code        string `json:"code"`
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	DebitTotal  int64  `json:"debitTotal"`
+	CreditTotal int64  `json:"creditTotal"`
+	Debit       int64  `json:"debit"`  // 借方余额
+	Credit      int64  `json:"credit"` // 贷方余额
+}
+
+type TrialBalance struct {
+	Rows        []TrialBalanceRow `json:"rows"`
+	DebitTotal  int64             `json:"debitTotal"`  // 借方余额合计
+	CreditTotal int64             `json:"creditTotal"` // 贷方余额合计
+	Balanced    bool              `json:"balanced"`
+}
+
+type LedgerQuery struct {
+	AccountCode string `json:"accountCode"`
+	Bookmark    string `json:"bookmark"`
+	PageSize    int32  `json:"pageSize"`
+}
+
+// 新增会计科目，需由 admin 或 accountant 属性为 true 的身份调用
+// code string required 字母、数字、点或横线
+// name string required
+// type string required asset、liability、equity、income 或 expense
+func (t *Fund) addLedgerAccount(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return errorResponse(CodeInvalidArgument, "should have 1 args")
+	}
+	err := checkBookkeeper(stub)
+	if err != nil {
+		return errorResponse(CodePermissionDenied, err.Error())
+	}
+	account := LedgerAccount{}
+	decoder := json.NewDecoder(strings.NewReader(args[0]))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&account)
+	if err != nil {
+		return errorResponse(CodeInvalidArgument, "invalid ledger account: "+err.Error())
+	}
+	if !ledgerCodePattern.MatchString(account.Code) {
+		return errorResponse(CodeInvalidArgument, "code should only contain letters, digits, dots and dashes, get "+account.Code)
+	}
+	if account.Name == "" {
+		return errorResponse(CodeInvalidArgument, "name is required")
+	}
+	if !isLedgerType(account.Type) {
+		return errorResponse(CodeInvalidArgument, "type should be asset, liability, equity, income or expense, get "+account.Type)
+	}
+	existing, err := getLedgerAccount(stub, account.Code)
+	if err != nil {
+		return errorResponse(CodeInternal, err.Error())
+	}
+	if existing != nil {
+		return errorResponse(CodeAlreadyExists, "ledger account "+account.Code+" already exists")
+	}
+	account.DebitTotal = 0
+	account.CreditTotal = 0
+	account.Seq = 0
+	account.Version = 0
+	err = putLedgerAccount(stub, &account)
+	if err != nil {
+		return errorResponse(CodeInternal, err.Error())
+	}
+	return shim.Success([]byte(account.Code))
+}
+
+// 查询会计科目表，按科目代码排序
+// res : [LedgerAccount]
+func (t *Fund) getChartOfAccounts(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 0 {
+		return errorResponse(CodeInvalidArgument, "should have 0 args")
+	}
+	accounts, err := getLedgerAccounts(stub)
+	if err != nil {
+		return errorResponse(CodeInternal, err.Error())
+	}
+	res, err := json.Marshal(&accounts)
+	if err != nil {
+		return errorResponse(CodeInternal, "failed to marshal ledger accounts:"+err.Error())
+	}
+	return shim.Success(res)
+}
+
+// 过账凭证，借贷不平、科目不存在或凭证号重复时背书失败；需由 admin 或 accountant 属性为 true 的身份调用
+// id string required
+// date string required yyyy-MM-dd
+// memo string
+// lines [JournalLine] required 至少两条，每条借方或贷方金额有且只有一个大于 0
+func (t *Fund) postJournalEntry(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return errorResponse(CodeInvalidArgument, "should have 1 args")
+	}
+	err := checkBookkeeper(stub)
+	if err != nil {
+		return errorResponse(CodePermissionDenied, err.Error())
+	}
+	entry := JournalEntry{}
+	decoder := json.NewDecoder(strings.NewReader(args[0]))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&entry)
+	if err != nil {
+		return errorResponse(CodeInvalidArgument, "invalid journal entry: "+err.Error())
+	}
+	err = validateJournalEntry(&entry)
+	if err != nil {
+		return errorResponse(CodeInvalidArgument, err.Error())
+	}
+	existing, err := getJournalEntry(stub, entry.ID)
+	if err != nil {
+		return errorResponse(CodeInternal, err.Error())
+	}
+	if existing != nil {
+		return errorResponse(CodeAlreadyExists, "journal entry "+entry.ID+" already exists")
+	}
+
+	// 同一科目可能出现在多条分录中，同一交易内读不到刚写入的状态，因此在内存中累计
+	// codes 按科目在分录中首次出现的顺序记录，写入顺序决定事件内容，各背书节点需保持一致
+	accounts := make(map[string]*LedgerAccount)
+	codes := make([]string, 0, len(entry.Lines))
+	for _, line := range entry.Lines {
+		if accounts[line.AccountCode] != nil {
+			continue
+		}
+		account, err := getLedgerAccount(stub, line.AccountCode)
+		if err != nil {
+			return errorResponse(CodeInternal, err.Error())
+		}
+		if account == nil {
+			return errorResponse(CodeNotFound, "ledger account "+line.AccountCode+" "+ErrorNotFound)
+		}
+		accounts[line.AccountCode] = account
+		codes = append(codes, line.AccountCode)
+	}
+
+	caller, err := getCaller(stub)
+	if err != nil {
+		return errorResponse(CodePermissionDenied, err.Error())
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return errorResponse(CodeInternal, err.Error())
+	}
+	entry.PostedBy = caller
+	entry.PostTxId = stub.GetTxID()
+	entry.PostTime = txTime.Format(time.RFC3339)
+
+	for _, line := range entry.Lines {
+		account := accounts[line.AccountCode]
+		if account.DebitTotal > math.MaxInt64-line.Debit || account.CreditTotal > math.MaxInt64-line.Credit {
+			return errorResponse(CodeFailedPrecondition, "total of ledger account "+account.Code+" overflows")
+		}
+		account.DebitTotal += line.Debit
+		account.CreditTotal += line.Credit
+		account.Seq++
+		err = putLedgerLine(stub, &LedgerLine{
+			AccountCode: account.Code,
+			Seq:         account.Seq,
+			EntryID:     entry.ID,
+			Date:        entry.Date,
+			Debit:       line.Debit,
+			Credit:      line.Credit,
+			Balance:     ledgerBalance(account),
+			Memo:        line.Memo,
+			TxId:        entry.PostTxId,
+		})
+		if err != nil {
+			return errorResponse(CodeInternal, err.Error())
+		}
+	}
+	for _, code := range codes {
+		err = putLedgerAccount(stub, accounts[code])
+		if err != nil {
+			return errorResponse(CodeInternal, err.Error())
+		}
+	}
+	err = putJournalEntry(stub, &entry)
+	if err != nil {
+		return errorResponse(CodeInternal, err.Error())
+	}
+	return shim.Success([]byte(stub.GetTxID()))
+}
+
+// 根据凭证号查询凭证
+// id string required
+// res : JournalEntry
+func (t *Fund) getJournalEntry(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return errorResponse(CodeInvalidArgument, "should have 1 args")
+	}
+	entry, err := getJournalEntry(stub, args[0])
+	if err != nil {
+		return errorResponse(CodeInternal, err.Error())
+	}
+	if entry == nil {
+		return errorResponse(CodeNotFound, ErrorNotFound)
+	}
+	res, err := json.Marshal(entry)
+	if err != nil {
+		return errorResponse(CodeInternal, "failed to marshal journal entry:"+err.Error())
+	}
+	return shim.Success(res)
+}
+
+// 试算平衡表，列出所有科目的累计发生额和余额，借方余额合计应等于贷方余额合计
+// res : {"rows": [TrialBalanceRow], "debitTotal", "creditTotal", "balanced"}
+func (t *Fund) getTrialBalance(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 0 {
+		return errorResponse(CodeInvalidArgument, "should have 0 args")
+	}
+	accounts, err := getLedgerAccounts(stub)
+	if err != nil {
+		return errorResponse(CodeInternal, err.Error())
+	}
+	trialBalance := TrialBalance{Rows: make([]TrialBalanceRow, 0, len(accounts))}
+	for _, account := range accounts {
+		row := TrialBalanceRow{
+			Code:        account.Code,
+			Name:        account.Name,
+			Type:        account.Type,
+			DebitTotal:  account.DebitTotal,
+			CreditTotal: account.CreditTotal,
+		}
+		if account.DebitTotal >= account.CreditTotal {
+			row.Debit = account.DebitTotal - account.CreditTotal
+		} else {
+			row.Credit = account.CreditTotal - account.DebitTotal
+		}
+		trialBalance.DebitTotal += row.Debit
+		trialBalance.CreditTotal += row.Credit
+		trialBalance.Rows = append(trialBalance.Rows, row)
+	}
+	trialBalance.Balanced = trialBalance.DebitTotal == trialBalance.CreditTotal
+	res, err := json.Marshal(&trialBalance)
+	if err != nil {
+		return errorResponse(CodeInternal, "failed to marshal trial balance:"+err.Error())
+	}
+	return shim.Success(res)
+}
+
+// 分页查询科目明细账，按过账顺序排列
+// accountCode string required
+// bookmark string
+// pageSize int required
+// res : {data:[LedgerLine],"bookmark": "bookmark"}
+func (t *Fund) getLedger(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return errorResponse(CodeInvalidArgument, "should have 1 args")
+	}
+	argStruct := LedgerQuery{}
+	err := json.Unmarshal([]byte(args[0]), &argStruct)
+	if err != nil {
+		return errorResponse(CodeInvalidArgument, "failed to unmarshal argStruct:"+err.Error())
+	}
+	if argStruct.AccountCode == "" {
+		return errorResponse(CodeInvalidArgument, "accountCode is required")
+	}
+	if argStruct.PageSize <= 0 {
+		return errorResponse(CodeInvalidArgument, "pageSize should be greater than 0")
+	}
+	resultsIterator, responseMetadata, err := stub.GetStateByPartialCompositeKeyWithPagination(ledgerLineObjectType, []string{argStruct.AccountCode}, argStruct.PageSize, argStruct.Bookmark)
+	if err != nil {
+		return errorResponse(CodeInternal, err.Error())
+	}
+	defer resultsIterator.Close()
+
+	data := make([]LedgerLine, 0)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(CodeInternal, "failed get resultsIterator:"+err.Error())
+		}
+		line := LedgerLine{}
+		err = json.Unmarshal(queryResponse.Value, &line)
+		if err != nil {
+			return errorResponse(CodeInternal, "failed to unmarshal ledger line:"+err.Error())
+		}
+		data = append(data, line)
+	}
+	res := map[string]interface{}{
+		"data":     data,
+		"bookmark": responseMetadata.Bookmark,
+	}
+	resStr, err := json.Marshal(&res)
+	if err != nil {
+		return errorResponse(CodeInternal, "failed to marshal res"+err.Error())
+	}
+	return shim.Success(resStr)
+}
+
+func validateJournalEntry(entry *JournalEntry) error {
+	if entry.ID == "" {
+		return fmt.Errorf("id is required")
+	}
+	_, err := time.Parse(dateLayout, entry.Date)
+	if err != nil {
+		return fmt.Errorf("date should be yyyy-MM-dd, get %s", entry.Date)
+	}
+	if len(entry.Lines) < 2 {
+		return fmt.Errorf("journal entry should have at least 2 lines")
+	}
+	var debitTotal, creditTotal int64
+	for i, line := range entry.Lines {
+		if line.AccountCode == "" {
+			return fmt.Errorf("line %d: accountCode is required", i+1)
+		}
+		if line.Debit < 0 || line.Credit < 0 {
+			return fmt.Errorf("line %d: debit and credit should not be negative", i+1)
+		}
+		if (line.Debit > 0) == (line.Credit > 0) {
+			return fmt.Errorf("line %d: exactly one of debit and credit should be greater than 0", i+1)
+		}
+		if debitTotal > math.MaxInt64-line.Debit || creditTotal > math.MaxInt64-line.Credit {
+			return fmt.Errorf("line %d: total amount overflows", i+1)
+		}
+		debitTotal += line.Debit
+		creditTotal += line.Credit
+	}
+	if debitTotal != creditTotal {
+		return fmt.Errorf("journal entry is unbalanced: debit %d, credit %d", debitTotal, creditTotal)
+	}
+	return nil
+}
+
+// 记账需要 admin 或 accountant 属性为 true
+func checkBookkeeper(stub shim.ChaincodeStubInterface) error {
+	return checkAnyAttribute(stub, "admin", "accountant")
+}
+
+// 校验调用者证书中 attrs 至少有一个属性为 true
+func checkAnyAttribute(stub shim.ChaincodeStubInterface, attrs ...string) error {
+	for _, attr := range attrs {
+		value, found, err := cid.GetAttributeValue(stub, attr)
+		if err != nil {
+			return fmt.Errorf("failed to get attribute %s: %s", attr, err.Error())
+		}
+		if found && value == "true" {
+			return nil
+		}
+	}
+	return fmt.Errorf("only %s can do this", strings.Join(attrs, " or "))
+}
+
+func isLedgerType(ledgerType string) bool {
+	switch ledgerType {
+	case LedgerTypeAsset, LedgerTypeLiability, LedgerTypeEquity, LedgerTypeIncome, LedgerTypeExpense:
+		return true
+	}
+	return false
+}
+
+// 按科目正常方向计算余额
+func ledgerBalance(account *LedgerAccount) int64 {
+	if account.Type == LedgerTypeAsset || account.Type == LedgerTypeExpense {
+		return account.DebitTotal - account.CreditTotal
+	}
+	return account.CreditTotal - account.DebitTotal
+}
+
+func getLedgerAccount(stub shim.ChaincodeStubInterface, code string) (*LedgerAccount, error) {
+	key, err := stub.CreateCompositeKey(ledgerAccountObjectType, []string{code})
+	if err != nil {
+		return nil, err
+	}
+	jsonVal, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if jsonVal == nil {
+		return nil, nil
+	}
+	account := LedgerAccount{}
+	err = json.Unmarshal(jsonVal, &account)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal ledger account %s: %s", code, err.Error())
+	}
+	return &account, nil
+}
+
+func getLedgerAccounts(stub shim.ChaincodeStubInterface) ([]LedgerAccount, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(ledgerAccountObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	accounts := make([]LedgerAccount, 0)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		account := LedgerAccount{}
+		err = json.Unmarshal(queryResponse.Value, &account)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal ledger account: %s", err.Error())
+		}
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].Code < accounts[j].Code
+	})
+	return accounts, nil
+}
+
+// 调用方持有读取时的科目，写入时版本加 1
+func putLedgerAccount(stub shim.ChaincodeStubInterface, account *LedgerAccount) error {
+	account.Version++
+	key, err := stub.CreateCompositeKey(ledgerAccountObjectType, []string{account.Code})
+	if err != nil {
+		return err
+	}
+	jsonVal, err := json.Marshal(account)
+	if err != nil {
+		return fmt.Errorf("failed to marshal ledger account: %s", err.Error())
+	}
+	return putRecord(stub, ledgerAccountObjectType, account.Code, key, jsonVal)
+}
+
+func getJournalEntry(stub shim.ChaincodeStubInterface, id string) (*JournalEntry, error) {
+	key, err := stub.CreateCompositeKey(journalEntryObjectType, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	jsonVal, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if jsonVal == nil {
+		return nil, nil
+	}
+	entry := JournalEntry{}
+	err = json.Unmarshal(jsonVal, &entry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal journal entry %s: %s", id, err.Error())
+	}
+	return &entry, nil
+}
+
+func putJournalEntry(stub shim.ChaincodeStubInterface, entry *JournalEntry) error {
+	key, err := stub.CreateCompositeKey(journalEntryObjectType, []string{entry.ID})
+	if err != nil {
+		return err
+	}
+	jsonVal, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal journal entry: %s", err.Error())
+	}
+	return putRecord(stub, journalEntryObjectType, entry.ID, key, jsonVal)
+}
+
+func putLedgerLine(stub shim.ChaincodeStubInterface, line *LedgerLine) error {
+	// 序号补齐位数，保证按 key 排序即为过账顺序
+	key, err := stub.CreateCompositeKey(ledgerLineObjectType, []string{line.AccountCode, fmt.Sprintf("%020d", line.Seq)})
+	if err != nil {
+		return err
+	}
+	jsonVal, err := json.Marshal(line)
+	if err != nil {
+		return fmt.Errorf("failed to marshal ledger line: %s", err.Error())
+	}
+	return stub.PutState(key, jsonVal)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	// 组合键的前缀，与 shim 中的定义一致
+	compositeKeyNamespace = "\x00"
+	// 范围查询的上界，MockStub 在指定 startKey 且 endKey 为空时不返回结果，因此用最大的 unicode 字符代替空值
+	maxSimpleKey = "\U0010FFFF"
+)
+
+type MigrateResult struct {
+	Migrated int              `json:"migrated"` // 本次迁移的数量
+	Failed   []MigrateFailure `json:"failed"`   // 本次跳过的记录，仍保留在原 key 下
+	NextKey  string           `json:"nextKey"`  // 下一批的起始 key，为空表示已扫描完
+}
+
+type MigrateFailure struct {
+	Key    string `json:"key"`
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// 将升级前以 id 为 key 的资金记录迁移到组合键 fundBill[id]，需由 admin 属性为 true 的身份调用
+// 内容无法解析、id 不一致或组合键下已存在的记录会被跳过并在 failed 中返回，不影响其他记录的迁移
+// 记录较多时可分批调用，将返回的 nextKey 作为下一次的 startKey，直到 nextKey 为空
+// limit string 可选，本次最多处理的数量，包括跳过的记录
+// startKey string 可选，本次扫描的起始 key
+// res : MigrateResult
+func (t *Fund) migrateKeys(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) > 2 {
+		return errorResponse(CodeInvalidArgument, "should have 0 to 2 args")
+	}
+	limit := 0
+	if len(args) >= 1 && args[0] != "" {
+		var err error
+		limit, err = strconv.Atoi(args[0])
+		if err != nil || limit <= 0 {
+			return errorResponse(CodeInvalidArgument, "limit should be a positive integer, get "+args[0])
+		}
+	}
+	startKey := ""
+	if len(args) == 2 {
+		startKey = args[1]
+	}
+	err := cid.AssertAttributeValue(stub, "admin", "true")
+	if err != nil {
+		return errorResponse(CodePermissionDenied, "only admin can do this: "+err.Error())
+	}
+
+	// fabric 的范围查询不会返回组合键，这里的判断用于兼容 MockStub
+	resultsIterator, err := stub.GetStateByRange(startKey, maxSimpleKey)
+	if err != nil {
+		return errorResponse(CodeInternal, err.Error())
+	}
+	defer resultsIterator.Close()
+
+	result := MigrateResult{Failed: make([]MigrateFailure, 0)}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(CodeInternal, "failed get resultsIterator:"+err.Error())
+		}
+		if strings.HasPrefix(queryResponse.Key, compositeKeyNamespace) {
+			continue
+		}
+		if limit > 0 && result.Migrated+len(result.Failed) >= limit {
+			result.NextKey = queryResponse.Key
+			break
+		}
+		code, err := migrateFundBill(stub, queryResponse.Key, queryResponse.Value)
+		if code == CodeInternal {
+			return errorResponse(code, err.Error())
+		}
+		if err != nil {
+			result.Failed = append(result.Failed, MigrateFailure{Key: queryResponse.Key, Code: code, Reason: err.Error()})
+			continue
+		}
+		result.Migrated++
+	}
+	res, err := json.Marshal(&result)
+	if err != nil {
+		return errorResponse(CodeInternal, "failed to marshal res"+err.Error())
+	}
+	return shim.Success(res)
+}
+
+// 迁移一条资金记录，记录不能迁移时返回对应的错误码，读写状态出错时返回 INTERNAL
+func migrateFundBill(stub shim.ChaincodeStubInterface, id string, jsonVal []byte) (string, error) {
+	bill := FundBill{}
+	err := json.Unmarshal(jsonVal, &bill)
+	if err != nil {
+		return CodeFailedPrecondition, fmt.Errorf("failed to unmarshal fund bill %s: %s", id, err.Error())
+	}
+	if bill.ID != id {
+		return CodeFailedPrecondition, fmt.Errorf("id in value should be %s, get %s", id, bill.ID)
+	}
+	existing, err := getFundBill(stub, id)
+	if err != nil {
+		return CodeInternal, err
+	}
+	if existing != nil {
+		return CodeAlreadyExists, fmt.Errorf("fund bill %s already exists under the namespaced key", id)
+	}
+	key, err := fundBillKey(stub, id)
+	if err != nil {
+		return CodeInternal, err
+	}
+	err = stub.PutState(key, jsonVal)
+	if err != nil {
+		return CodeInternal, err
+	}
+	err = stub.DelState(id)
+	if err != nil {
+		return CodeInternal, err
+	}
+	return "", nil
+}
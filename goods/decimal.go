@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// 数量和金额以定点小数计算：按小数位数放大为整数，避免浮点误差
+const (
+	configObjectType = "config"
+	decimalScalesKey = "decimalScales"
+
+	maxDecimalScale   = 6
+	maxIntegerDigits  = 12 // 放大后不超过 int64
+	defaultQtyScale   = 3  // 与此前 FormatFloat 保留的位数一致
+	defaultMoneyScale = 2
+)
+
+var decimalPattern = regexp.MustCompile(`^(\d{1,12})(?:\.(\d+))?$`)
+
+// 小数位数配置；quantity 用于库存数量、上架数量和重量，money 用于单价和订单金额
+// units 按计量单位覆盖数量位数，key 为单位代码，未配置的单位使用 quantity
+type DecimalScales struct {
+	Quantity int            `json:"quantity"`
+	Money    int            `json:"money"`
+	Units    map[string]int `json:"units,omitempty"`
+}
+
+// 返回指定单位的数量小数位数，未单独配置时使用 quantity
+func (scales *DecimalScales) quantityScale(unitCode string) int {
+	if scale, ok := scales.Units[unitCode]; ok {
+		return scale
+	}
+	return scales.Quantity
+}
+
+// 设置小数位数，需由 admin 属性为 true 的身份调用；已有记录在下次写入时按新的位数格式化
+// 数量位数可按单位配置，商品的库存、预留和订单重量按商品单位的位数格式化
+// 已存储的值按原位数写入，因此 quantity、money 只能增加不能减少；单位的位数变小时需该单位已有的数量都不超出新的位数
+// value string required {"quantity": 3, "money": 2}，可选 units 如 {"piece": 0}，单位需已登记，取值 0 到 6
+func setDecimalScales(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("args length should be 1")
+	}
+	err := cid.AssertAttributeValue(stub, "admin", "true")
+	if err != nil {
+		return shim.Error("only admin can do this: " + err.Error())
+	}
+	scales := DecimalScales{}
+	err = json.Unmarshal([]byte(args[0]), &scales)
+	if err != nil {
+		return shim.Error("unmarshal decimal scales failed" + err.Error())
+	}
+	for _, scale := range []int{scales.Quantity, scales.Money} {
+		if scale < 0 || scale > maxDecimalScale {
+			return shim.Error(fmt.Sprintf("scale should be between 0 and %d, get %d", maxDecimalScale, scale))
+		}
+	}
+	for unitCode, scale := range scales.Units {
+		if scale < 0 || scale > maxDecimalScale {
+			return shim.Error(fmt.Sprintf("scale of unit %s should be between 0 and %d, get %d", unitCode, maxDecimalScale, scale))
+		}
+		if unitCode == "" {
+			return shim.Error("unit code of scales is required")
+		}
+		_, err = lookupUnit(stub, unitCode)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	current, err := getDecimalScales(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if scales.Quantity < current.Quantity || scales.Money < current.Money {
+		return shim.Error(fmt.Sprintf("scales can only be increased, current quantity %d, money %d", current.Quantity, current.Money))
+	}
+	// 单位的生效位数变小时，该单位的商品和预留中已存储的数量都需能按新的位数解析
+	for _, unitCode := range unitCodes(current.Units, scales.Units) {
+		if scales.quantityScale(unitCode) < current.quantityScale(unitCode) {
+			err = checkUnitScale(stub, unitCode, scales.quantityScale(unitCode))
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+	}
+	key, err := stub.CreateCompositeKey(configObjectType, []string{decimalScalesKey})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	jsonVal, err := json.Marshal(&scales)
+	if err != nil {
+		return shim.Error("failed to marshal decimal scales:" + err.Error())
+	}
+	err = stub.PutState(key, jsonVal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(stub.GetTxID()))
+}
+
+// 查询小数位数配置，未设置时返回默认值
+// res : DecimalScales
+func queryDecimalScales(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 0 {
+		return shim.Error("args length should be 0")
+	}
+	scales, err := getDecimalScales(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	res, err := json.Marshal(scales)
+	if err != nil {
+		return shim.Error("failed to marshal res" + err.Error())
+	}
+	return shim.Success(res)
+}
+
+func getDecimalScales(stub shim.ChaincodeStubInterface) (*DecimalScales, error) {
+	scales := &DecimalScales{Quantity: defaultQtyScale, Money: defaultMoneyScale}
+	key, err := stub.CreateCompositeKey(configObjectType, []string{decimalScalesKey})
+	if err != nil {
+		return nil, err
+	}
+	jsonVal, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if jsonVal == nil {
+		return scales, nil
+	}
+	err = json.Unmarshal(jsonVal, scales)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal decimal scales: %s", err.Error())
+	}
+	return scales, nil
+}
+
+// 校验单位为 unitCode 的商品的数量和预留数量都能按 scale 位小数解析，商品存储为简单键，按范围查询遍历
+func checkUnitScale(stub shim.ChaincodeStubInterface, unitCode string, scale int) error {
+	resultsIterator, err := stub.GetStateByRange("", "")
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		goods := Goods{}
+		err = json.Unmarshal(queryResponse.Value, &goods)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal goods %s: %s", queryResponse.Key, err.Error())
+		}
+		if goods.Unit != unitCode {
+			continue
+		}
+		for _, value := range []string{goods.StockNum, goods.Amount, goods.Weight} {
+			_, err = parseStoredDecimal("quantity", value, scale)
+			if err != nil {
+				return fmt.Errorf("scale of unit %s can not be decreased to %d, goods %s: %s", unitCode, scale, goods.StockId, err.Error())
+			}
+		}
+		// 以零时刻汇总，预留中的记录都视为未过期而逐条解析
+		_, err = getReservedUnits(stub, goods.StockId, time.Time{}, scale)
+		if err != nil {
+			return fmt.Errorf("scale of unit %s can not be decreased to %d, goods %s: %s", unitCode, scale, goods.StockId, err.Error())
+		}
+	}
+	return nil
+}
+
+// 合并多组单位配置中的单位代码，map 遍历无序，排序后保证各背书节点报错一致
+func unitCodes(groups ...map[string]int) []string {
+	seen := make(map[string]bool)
+	codes := make([]string, 0)
+	for _, group := range groups {
+		for code := range group {
+			if !seen[code] {
+				seen[code] = true
+				codes = append(codes, code)
+			}
+		}
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// 校验并按小数位数格式化 goods 中的数量和单价，为空的字段不处理；数量按商品单位的位数
+func normalizeGoods(goods *Goods, scales *DecimalScales) error {
+	quantityScale := scales.quantityScale(goods.Unit)
+	fields := []struct {
+		name  string
+		value *string
+		scale int
+	}{
+		{"stockNum", &goods.StockNum, quantityScale},
+		{"amount", &goods.Amount, quantityScale},
+		{"weight", &goods.Weight, quantityScale},
+		{"price", &goods.Price, scales.Money},
+	}
+	for _, field := range fields {
+		if *field.value == "" {
+			continue
+		}
+		units, err := parseDecimal(field.name, *field.value, field.scale)
+		if err != nil {
+			return err
+		}
+		*field.value = formatDecimal(units, field.scale)
+	}
+	return nil
+}
+
+// 解析非负小数，返回放大 10^scale 倍后的整数；超出 scale 的小数位只能是 0
+func parseDecimal(field string, value string, scale int) (int64, error) {
+	match := decimalPattern.FindStringSubmatch(value)
+	if match == nil {
+		return 0, fmt.Errorf("%s should be a non-negative decimal with at most %d integer digits, get %s", field, maxIntegerDigits, value)
+	}
+	fraction := match[2]
+	if len(fraction) > scale {
+		if strings.Trim(fraction[scale:], "0") != "" {
+			return 0, fmt.Errorf("%s should have at most %d decimal places, get %s", field, scale, value)
+		}
+		fraction = fraction[:scale]
+	}
+	fraction += strings.Repeat("0", scale-len(fraction))
+	units, _ := strconv.ParseInt(match[1]+fraction, 10, 64)
+	return units, nil
+}
+
+// 放大 10^scale 倍后允许的最大值，即 maxIntegerDigits 位整数；累加结果超出时无法再按 parseDecimal 读回
+func maxDecimalUnits(scale int) int64 {
+	max := int64(1)
+	for i := 0; i < maxIntegerDigits+scale; i++ {
+		max *= 10
+	}
+	return max - 1
+}
+
+// 按小数位数格式化，如 formatDecimal(12340, 3) 为 "12.340"
+func formatDecimal(units int64, scale int) string {
+	sign := ""
+	if units < 0 {
+		sign, units = "-", -units
+	}
+	digits := fmt.Sprintf("%0*d", scale+1, units)
+	if scale == 0 {
+		return sign + digits
+	}
+	return sign + digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+}
+
+// 已存储的值，为空时视为 0；引入定点小数之前可能已被扣减为负数
+func parseStoredDecimal(field string, value string, scale int) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if strings.HasPrefix(value, "-") {
+		units, err := parseDecimal(field, value[1:], scale)
+		return -units, err
+	}
+	return parseDecimal(field, value, scale)
+}
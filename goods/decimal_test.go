@@ -0,0 +1,149 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestParseDecimal(t *testing.T) {
+	valid := map[string]int64{"0": 0, "1": 1000, "1.5": 1500, "0.001": 1, "2.1000": 2100, "999999999999.999": 999999999999999}
+	for value, expect := range valid {
+		units, err := parseDecimal("stockNum", value, 3)
+		if err != nil || units != expect {
+			t.Fatal(value, units, err)
+		}
+	}
+	for _, value := range []string{"", "-1", "1.0001", "1e3", ".5", "1.", " 1", "1000000000000"} {
+		if _, err := parseDecimal("stockNum", value, 3); err == nil {
+			t.Fatal(value)
+		}
+	}
+	formats := map[string]string{formatDecimal(1500, 3): "1.500", formatDecimal(1, 2): "0.01", formatDecimal(-250, 2): "-2.50", formatDecimal(7, 0): "7"}
+	for actual, expect := range formats {
+		if actual != expect {
+			t.Fatal(actual, expect)
+		}
+	}
+}
+
+func TestUpdateGoodsAmount(t *testing.T) {
+	mockStub := shim.NewMockStub("goods", new(GoodsContract))
+	now := time.Now()
+	if res := invokeAt(mockStub, now, "tx1", "addGoods", `{"stockId":"s1","stockNum":"0.3","price":"12.5"}`); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if res := invokeAt(mockStub, now, "tx2", "addGoods", `{"stockId":"s2","price":"12.555"}`); res.Status == shim.OK {
+		t.Fatal("price with too much precision should be rejected")
+	}
+	// 浮点计算时 0.3 - 0.1 - 0.2 不为 0
+	for i, amount := range []string{"0.1", "0.2"} {
+		if res := invokeAt(mockStub, now, "sub"+amount, "updateGoodsAmount", "s1", amount, "0"); res.Status != shim.OK {
+			t.Fatal(i, res.Message)
+		}
+	}
+	goods, _ := getGoods(mockStub, "s1")
+	if goods.StockNum != "0.000" || goods.Price != "12.50" {
+		t.Fatal(goods)
+	}
+	if res := invokeAt(mockStub, now, "tx3", "updateGoodsAmount", "s1", "0.001", "0"); res.Status == shim.OK {
+		t.Fatal("stock should not go negative")
+	}
+	if res := invokeAt(mockStub, now, "tx4", "updateGoodsAmount", "s1", "abc", "1"); res.Status == shim.OK {
+		t.Fatal("malformed amount should be rejected")
+	}
+
+	if res := invokeAt(mockStub, now, "tx5", "setDecimalScales", `{"quantity":4,"money":2}`); res.Status == shim.OK {
+		t.Fatal("only admin can set decimal scales")
+	}
+	admin := newCreator("Org1MSP", "admin", map[string]string{"admin": "true"})
+	if res := invokeAs(mockStub, admin, now, "tx6", "setDecimalScales", `{"quantity":1,"money":2}`); res.Status == shim.OK {
+		t.Fatal("scales should not be decreased")
+	}
+	if res := invokeAs(mockStub, admin, now, "tx7", "setDecimalScales", `{"quantity":4,"money":2}`); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if res := invokeAt(mockStub, now, "tx8", "updateGoodsAmount", "s1", "0.0005", "1"); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if goods, _ = getGoods(mockStub, "s1"); goods.StockNum != "0.0005" {
+		t.Fatal(goods)
+	}
+}
+
+func TestStockNumOverflow(t *testing.T) {
+	mockStub := shim.NewMockStub("goods", new(GoodsContract))
+	now := time.Now()
+	if res := invokeAt(mockStub, now, "tx1", "addGoods", `{"stockId":"s1","stockNum":"999999999999"}`); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if res := invokeAt(mockStub, now, "tx2", "updateGoodsAmount", "s1", "0.999", "1"); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if res := invokeAt(mockStub, now, "tx3", "updateGoodsAmount", "s1", "0.001", "1"); res.Status == shim.OK {
+		t.Fatal("stockNum should not exceed 12 integer digits")
+	}
+	if goods, _ := getGoods(mockStub, "s1"); goods.StockNum != "999999999999.999" {
+		t.Fatal(goods)
+	}
+	if res := invokeAt(mockStub, now, "tx4", "reserveGoods", `{"reservationId":"r1","stockId":"s1","buyer":"b1","quantity":"1","expireTime":"`+now.Add(time.Hour).Format(time.RFC3339)+`"}`); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+}
+
+func TestUnitDecimalScales(t *testing.T) {
+	mockStub := shim.NewMockStub("goods", new(GoodsContract))
+	now := time.Now()
+	admin := newCreator("Org1MSP", "admin", map[string]string{"admin": "true"})
+	if res := invokeAs(mockStub, admin, now, "tx1", "setDecimalScales", `{"quantity":3,"money":2,"units":{"box":0}}`); res.Status == shim.OK {
+		t.Fatal("unit should be registered")
+	}
+	if res := invokeAt(mockStub, now, "tx2", "addGoods", `{"stockId":"s0","stockNum":"1.5","unit":"piece"}`); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if res := invokeAs(mockStub, admin, now, "tx2a", "setDecimalScales", `{"quantity":3,"money":2,"units":{"piece":0}}`); res.Status == shim.OK {
+		t.Fatal("stockNum of s0 does not fit the new scale")
+	}
+	if res := invokeAt(mockStub, now, "tx2b", "updateGoodsAmount", "s0", "0.5", "1"); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if res := invokeAs(mockStub, admin, now, "tx2c", "setDecimalScales", `{"quantity":3,"money":2,"units":{"piece":0}}`); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if res := invokeAt(mockStub, now, "tx3", "addGoods", `{"stockId":"s1","stockNum":"1.5","unit":"piece"}`); res.Status == shim.OK {
+		t.Fatal("piece should have no decimal places")
+	}
+	if res := invokeAt(mockStub, now, "tx4", "addGoods", `{"stockId":"s1","stockNum":"2","unit":"piece"}`); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if res := invokeAt(mockStub, now, "tx5", "addGoods", `{"stockId":"s2","stockNum":"1.5","unit":"kg"}`); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if goods, _ := getGoods(mockStub, "s1"); goods.StockNum != "2" {
+		t.Fatal(goods)
+	}
+	if goods, _ := getGoods(mockStub, "s2"); goods.StockNum != "1.500" {
+		t.Fatal(goods)
+	}
+	if res := invokeAt(mockStub, now, "tx6", "updateGoodsAmount", "s1", "0.5", "0"); res.Status == shim.OK {
+		t.Fatal("piece should have no decimal places")
+	}
+
+	// kg 改为 4 位后写入 4 位小数，去掉单独配置回到默认的 3 位时已存储的值无法解析
+	if res := invokeAs(mockStub, admin, now, "tx7", "setDecimalScales", `{"quantity":3,"money":2,"units":{"piece":0,"kg":4}}`); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if res := invokeAt(mockStub, now, "tx8", "updateGoodsAmount", "s2", "0.0005", "1"); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if goods, _ := getGoods(mockStub, "s2"); goods.StockNum != "1.5005" {
+		t.Fatal(goods)
+	}
+	if res := invokeAs(mockStub, admin, now, "tx9", "setDecimalScales", `{"quantity":3,"money":2,"units":{"piece":0}}`); res.Status == shim.OK {
+		t.Fatal("stockNum of s2 does not fit the new scale")
+	}
+	if res := invokeAs(mockStub, admin, now, "tx10", "setDecimalScales", `{"quantity":2,"money":2,"units":{"piece":0,"kg":4}}`); res.Status == shim.OK {
+		t.Fatal("default quantity scale should not be decreased")
+	}
+}
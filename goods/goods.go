@@ -7,6 +7,7 @@ import (
 	"reflect"
 	"strconv"
 
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	"github.com/hyperledger/fabric/protos/peer"
 )
@@ -26,17 +27,25 @@ type Goods struct {
 	ShopId      string `json:"shopId"`      // 摊位编号
 	Amount      string `json:"amount"`      // 上架数量
 	StockNum    string `json:"stockNum"`    // 库存数量
+	Unit        string `json:"unit"`        // stockNum、amount、weight 和 price 的计量单位，为空表示引入单位之前的记录
 	IsSelf      string `json:"isSelf"`      // 0 非自产， 1 自产
 	FileName    string `json:"fileName"`    // 进货单
 	Desc        string `json:"desc"`        // 备注
 	StorageTime string `json:"storageTime"` // 入链时间
 	SubmitTime  string `json:"submitTime"`  // 提交时间
 	GsiStatus   string `json:"gsiStatus"`   // 上架状态 0 上架， 1下架
-	QcStatus    string `json:"qcstatus"`    // 0 未检测，1 合格， 2 不合格 3 复检合格 4 复检不合格
+	QcStatus    string `json:"qcstatus"`    // 0 未检测，1 合格， 2 不合格 3 复检合格 4 复检不合格，由检测记录修改
 	Shop        string `json:"shop"`
 	Name        string `json:"name"`
 	MarketId    string `json:"marketId"`
 	IsPreSell   string `json:"isPreSell"`
+	Version     int64  `json:"version"` // 版本，每次写入加 1，不需要传入
+}
+
+// 调用者身份，取自客户端证书
+type Caller struct {
+	MSPID   string `json:"mspId"`   // 所属组织
+	Subject string `json:"subject"` // 证书主题
 }
 
 type PersonalGoodsRes struct {
@@ -94,17 +103,54 @@ func (t GoodsContract) Invoke(stub shim.ChaincodeStubInterface) peer.Response {
 		return updateOrder(stub, args)
 	case "queryOrder":
 		return queryOrder(stub, args)
+	case "reserveGoods":
+		return reserveGoods(stub, args)
+	case "confirmReservation":
+		return confirmReservation(stub, args)
+	case "releaseReservation":
+		return releaseReservation(stub, args)
+	case "queryReservations":
+		return queryReservations(stub, args)
+	case "queryAvailableStock":
+		return queryAvailableStock(stub, args)
+	case "queryListingTimeline":
+		return queryListingTimeline(stub, args)
+	case "setUnit":
+		return setUnit(stub, args)
+	case "queryUnits":
+		return queryUnits(stub, args)
+	case "queryPriceHistory":
+		return queryPriceHistory(stub, args)
+	case "queryPriceTrend":
+		return queryPriceTrend(stub, args)
+	case "submitInspection":
+		return submitInspection(stub, args)
+	case "queryInspections":
+		return queryInspections(stub, args)
+	case "setDecimalScales":
+		return setDecimalScales(stub, args)
+	case "queryDecimalScales":
+		return queryDecimalScales(stub, args)
 	default:
 		return shim.Error("unsupported method " + fn)
 	}
 }
 
-// stockId 为主键
+// stockId 为主键，stockId 已存在时覆盖原记录
+// stockNum、amount、weight、price 为非负小数，按配置的小数位数格式化
+// unit 为空或已登记的单位；覆盖时修改单位需先确认或释放未过期的预留
+// qcstatus 只能由检测记录修改，新增时为 0，覆盖时保留原值
+// gsiStatus 新增时为 0、1 或空，覆盖时保留原值，需通过 updateGoodStatus 修改
+// expectedVersion string 可选，覆盖时与当前版本不一致则报错
 func addGoods(stub shim.ChaincodeStubInterface, args []string) peer.Response {
-	if len(args) != 1 {
-		return shim.Error("args length should be 1")
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("args length should be 1 or 2")
 	}
 	jsonValue := args[0]
+	expectedVersion := ""
+	if len(args) == 2 {
+		expectedVersion = args[1]
+	}
 	goods := Goods{}
 	err := json.Unmarshal([]byte(jsonValue), &goods)
 	if err != nil {
@@ -114,35 +160,115 @@ func addGoods(stub shim.ChaincodeStubInterface, args []string) peer.Response {
 	if id == "" {
 		return shim.Error("stockId is required")
 	}
-	err = stub.PutState(id, []byte(jsonValue))
+	scales, err := getDecimalScales(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = normalizeGoods(&goods, scales)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	existing, err := getGoods(stub, id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	_, err = lookupUnit(stub, goods.Unit)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	goods.Version = 0
+	goods.QcStatus = QcUntested
+	fromStatus, oldPrice, oldUnit := "", "", ""
+	if existing != nil {
+		if existing.Unit != goods.Unit {
+			// 预留数量按原单位保存
+			err = checkNoReservations(stub, existing)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+		goods.Version = existing.Version
+		goods.QcStatus = existing.QcStatus
+		goods.GsiStatus = existing.GsiStatus
+		fromStatus = existing.GsiStatus
+		oldPrice = existing.Price
+		oldUnit = existing.Unit
+	} else if goods.GsiStatus == "" {
+		// 为空的状态只保留给引入上下架状态之前的商品
+		goods.GsiStatus = GsiListed
+	} else if !isGsiStatus(goods.GsiStatus) {
+		return shim.Error("gsiStatus should be 0 or 1, get " + goods.GsiStatus)
+	}
+	err = checkVersion(id, goods.Version, expectedVersion)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putGoods(stub, &goods)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = addListingChange(stub, id, fromStatus, goods.GsiStatus, "addGoods")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = addPriceChange(stub, &goods, oldPrice, oldUnit)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 	return shim.Success([]byte(stub.GetTxID()))
 }
 
-// 根据 stockId 更新 gsiStatus 状态
-// stockId gsiStatus
+// 根据 stockId 更新 gsiStatus 状态，记录调用者、交易时间和原因
+// 检测不合格的商品不能上架；存在未过期的预留或未完成的订单时不能下架
+// stockId string required
+// gsiStatus string required 0 上架，1 下架
+// expectedVersion string 可选，与当前版本不一致时报错，只传 reason 时传空字符串
+// reason string 可选，修改原因
 func updateGoodStatus(stub shim.ChaincodeStubInterface, args []string) peer.Response {
-	if len(args) != 2 {
-		return shim.Error("args length should be 2")
+	if len(args) < 2 || len(args) > 4 {
+		return shim.Error("args length should be 2 to 4")
 	}
 	stockId, gsiStatus := args[0], args[1]
-	goodsJson, err := stub.GetState(stockId)
+	expectedVersion, reason := "", ""
+	if len(args) >= 3 {
+		expectedVersion = args[2]
+	}
+	if len(args) == 4 {
+		reason = args[3]
+	}
+	if !isGsiStatus(gsiStatus) {
+		return shim.Error("gsiStatus should be 0 or 1, get " + gsiStatus)
+	}
+	goods, err := getGoods(stub, stockId)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	goods := Goods{}
-	err = json.Unmarshal(goodsJson, &goods)
+	if goods == nil {
+		return shim.Error(ErrorNotFound)
+	}
+	err = checkVersion(stockId, goods.Version, expectedVersion)
 	if err != nil {
-		return shim.Error("failed to unmarshal goods:" + err.Error())
+		return shim.Error(err.Error())
+	}
+	if goods.GsiStatus == gsiStatus {
+		return shim.Error("goods " + stockId + " is already in gsiStatus " + gsiStatus)
+	}
+	if gsiStatus == GsiListed && qcFailed(goods) {
+		return shim.Error("goods " + stockId + " failed quality inspection and can not be listed")
 	}
+	if gsiStatus == GsiUnlisted {
+		err = checkUnlisting(stub, goods)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	fromStatus := goods.GsiStatus
 	goods.GsiStatus = gsiStatus
-	updateValue, err := json.Marshal(&goods)
+	err = putGoods(stub, goods)
 	if err != nil {
-		return shim.Error("failed to marshal goods:" + err.Error())
+		return shim.Error(err.Error())
 	}
-	err = stub.PutState(stockId, updateValue)
+	err = addListingChange(stub, stockId, fromStatus, gsiStatus, reason)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -356,61 +482,90 @@ func queryFriendGoodsListByMap(stub shim.ChaincodeStubInterface, args []string)
 
 }
 
-// 根据 stockId 更新 goods 库存
+// 根据 stockId 更新 goods 库存，减库存后不能为负数
 // stockId string required
-// amount string required
+// amount string required 正数，小数位数不超过配置
 // updateType string required 0减库存 1加库存
+// expectedVersion string 可选，与当前版本不一致时报错，只传 unit 时传空字符串
+// unit string 可选，amount 的单位，与 goods 的单位不同时换算
 func updateGoodsAmount(stub shim.ChaincodeStubInterface, args []string) peer.Response {
-	if len(args) != 3 {
-		return shim.Error("args length should be 3")
+	if len(args) < 3 || len(args) > 5 {
+		return shim.Error("args length should be 3 to 5")
 	}
 	stockId, amountStr, updateType := args[0], args[1], args[2]
+	expectedVersion, unit := "", ""
+	if len(args) >= 4 {
+		expectedVersion = args[3]
+	}
+	if len(args) == 5 {
+		unit = args[4]
+	}
 	if stockId == "" || amountStr == "" || updateType == "" {
 		return shim.Error("stockId, amount and updateType is required")
 	}
 	if updateType != "0" && updateType != "1" {
 		return shim.Error("updateType should be 0 or 1, get " + updateType)
 	}
-	// amount, err := strconv.Atoi(amountStr)
-	amount, err := strconv.ParseFloat(amountStr, 64)
+	goods, err := getGoods(stub, stockId)
 
 	if err != nil {
-		return shim.Error("failed to get amount" + err.Error())
+		return shim.Error(err.Error())
 	}
-
-	goodsStr, err := stub.GetState(stockId)
-
+	if goods == nil {
+		return shim.Error(ErrorNotFound)
+	}
+	// 数量位数按商品单位配置，换算前后都按该位数计算
+	scales, err := getDecimalScales(stub)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-
-	goods := Goods{}
-	err = json.Unmarshal(goodsStr, &goods)
-
+	scale := scales.quantityScale(goods.Unit)
+	amount, err := parseDecimal("amount", amountStr, scale)
 	if err != nil {
-		return shim.Error("failed to unmarshal goods" + err.Error())
+		return shim.Error(err.Error())
 	}
-	// stockNum, err := strconv.Atoi(goods.StockNum)
-	stockNum, err := strconv.ParseFloat(goods.StockNum, 64)
+	if amount == 0 {
+		return shim.Error("amount should be greater than 0")
+	}
+	err = checkVersion(stockId, goods.Version, expectedVersion)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	amount, err = toGoodsUnits(stub, goods, amount, scale, unit)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	stockNum, err := parseStoredDecimal("stockNum", goods.StockNum, scale)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
 	if updateType == "0" {
+		// 未过期的预留仍占用库存，扣减后不能少于预留数量
+		txTime, err := getTxTime(stub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		reserved, err := getReservedUnits(stub, stockId, txTime, scale)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if stockNum-amount < reserved {
+			return shim.Error(fmt.Sprintf("insufficient stock: %s in stock, %s reserved, %s requested",
+				formatDecimal(stockNum, scale), formatDecimal(reserved, scale), formatDecimal(amount, scale)))
+		}
 		stockNum -= amount
 	} else {
+		// 写入后的库存需仍能按 parseDecimal 读回，超出整数位数上限时拒绝
+		if amount > maxDecimalUnits(scale)-stockNum {
+			return shim.Error(fmt.Sprintf("stockNum would exceed %d integer digits: %s in stock, %s requested",
+				maxIntegerDigits, formatDecimal(stockNum, scale), formatDecimal(amount, scale)))
+		}
 		stockNum += amount
 	}
+	goods.StockNum = formatDecimal(stockNum, scale)
 
-	// stockNumStr := strconv.Itoa(stockNum)
-	stockNumStr := strconv.FormatFloat(stockNum, 'f', 3, 64)
-	goods.StockNum = stockNumStr
-
-	bytes, err := json.Marshal(&goods)
-	if err != nil {
-		return shim.Error(err.Error())
-	}
-	err = stub.PutState(stockId, bytes)
+	err = putGoods(stub, goods)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -435,42 +590,55 @@ func queryGoodsByStockId(stub shim.ChaincodeStubInterface, args []string) peer.R
 	return shim.Success(goodsStr)
 }
 
-// 更新 goods的进货单或价格
+// 更新 goods的进货单或价格，价格变化记录调用者和交易时间
 // stockId string
 // fileName string
-// price string
+// price string 非负小数，小数位数不超过配置
+// expectedVersion string 可选，与当前版本不一致时报错
 func updateGoodsStockFileNameOrPrice(stub shim.ChaincodeStubInterface, args []string) peer.Response {
-	if len(args) != 3 {
-		return shim.Error("args length should be 3")
+	if len(args) != 3 && len(args) != 4 {
+		return shim.Error("args length should be 3 or 4")
 	}
 	stockId, fileName, price := args[0], args[1], args[2]
+	expectedVersion := ""
+	if len(args) == 4 {
+		expectedVersion = args[3]
+	}
 	if stockId == "" {
 		return shim.Error("stockId is required")
 	}
-	goods := Goods{}
-	jsonVal, err := stub.GetState(stockId)
+	goods, err := getGoods(stub, stockId)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	if jsonVal == nil {
+	if goods == nil {
 		return shim.Error(ErrorNotFound)
 	}
-	err = json.Unmarshal(jsonVal, &goods)
+	err = checkVersion(stockId, goods.Version, expectedVersion)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 	if fileName != "" {
 		goods.FileName = fileName
 	}
+	oldPrice := goods.Price
 	if price != "" {
-		goods.Price = price
+		scales, err := getDecimalScales(stub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		units, err := parseDecimal("price", price, scales.Money)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		goods.Price = formatDecimal(units, scales.Money)
 	}
 
-	update, err := json.Marshal(&goods)
+	err = putGoods(stub, goods)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	err = stub.PutState(stockId, update)
+	err = addPriceChange(stub, goods, oldPrice, goods.Unit)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -527,22 +695,166 @@ func traceGoodsAndOrderByBatchNo(stub shim.ChaincodeStubInterface, args []string
 	return shim.Success(buffer.Bytes())
 }
 
+// 参数转发给 order 链码，包括可选的 expectedVersion；amount 和 weight 先按配置的小数位数校验并格式化
+// unit 需与 goodsStockId 对应 goods 的单位同一量纲，为空时取 goods 的单位
+// goodsStockId 对应的 goods 检测不合格或已下架时报错
 func addOrder(stub shim.ChaincodeStubInterface, args []string) peer.Response {
-	res := stub.InvokeChaincode(orderContractName, [][]byte{[]byte("addOrder"), []byte(args[0])}, stub.GetChannelID())
+	args, err := normalizeOrderArgs(stub, args, true)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkOrderGoods(stub, args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	res := stub.InvokeChaincode(orderContractName, orderArgs("addOrder", args), stub.GetChannelID())
 	if res.Status != shim.OK {
 		return shim.Error(res.Message)
 	}
 	return shim.Success(res.Payload)
 }
 
+// 修改 unit 或 goodsStockId 时，未传入的一方取原订单的值校验单位；修改 goodsStockId 时同 addOrder 校验 goods 的状态
 func updateOrder(stub shim.ChaincodeStubInterface, args []string) peer.Response {
-	res := stub.InvokeChaincode(orderContractName, [][]byte{[]byte("updateOrder"), []byte(args[0])}, stub.GetChannelID())
+	args, err := normalizeOrderArgs(stub, args, false)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkOrderGoods(stub, args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	res := stub.InvokeChaincode(orderContractName, orderArgs("updateOrder", args), stub.GetChannelID())
 	if res.Status != shim.OK {
 		return shim.Error(res.Message)
 	}
 	return shim.Success(res.Payload)
 }
 
+// 第一个参数为 order json，其余参数原样返回；json 格式错误时交由 order 链码报错
+// defaultUnit 为 true 时（新增订单），unit 为空则取 goods 的单位
+func normalizeOrderArgs(stub shim.ChaincodeStubInterface, args []string, defaultUnit bool) ([]string, error) {
+	if len(args) == 0 {
+		return args, nil
+	}
+	orderMap := make(map[string]interface{})
+	if json.Unmarshal([]byte(args[0]), &orderMap) != nil {
+		return args, nil
+	}
+	// weight 以订单的单位计量，先确定单位再按该单位的位数格式化
+	unitCode, err := checkOrderUnit(stub, orderMap, defaultUnit)
+	if err != nil {
+		return nil, err
+	}
+	scales, err := getDecimalScales(stub)
+	if err != nil {
+		return nil, err
+	}
+	fields := []struct {
+		name  string
+		scale int
+	}{
+		{"amount", scales.Money},
+		{"weight", scales.quantityScale(unitCode)},
+	}
+	for _, field := range fields {
+		value, ok := orderMap[field.name].(string)
+		if !ok || value == "" {
+			continue
+		}
+		units, err := parseDecimal(field.name, value, field.scale)
+		if err != nil {
+			return nil, err
+		}
+		orderMap[field.name] = formatDecimal(units, field.scale)
+	}
+	jsonVal, err := json.Marshal(orderMap)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal order: %s", err.Error())
+	}
+	return append([]string{string(jsonVal)}, args[1:]...), nil
+}
+
+// 订单传入 goodsStockId 时校验对应的 goods 可以售卖，goods 不存在时不做校验
+func checkOrderGoods(stub shim.ChaincodeStubInterface, args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	orderMap := make(map[string]interface{})
+	if json.Unmarshal([]byte(args[0]), &orderMap) != nil {
+		return nil
+	}
+	stockId, _ := orderMap["goodsStockId"].(string)
+	if stockId == "" {
+		return nil
+	}
+	goods, err := getGoods(stub, stockId)
+	if err != nil {
+		return err
+	}
+	if goods == nil {
+		return nil
+	}
+	return checkSellable(goods)
+}
+
+// 校验订单的单位已登记且与 goods 的单位兼容；goods 不存在或没有单位（引入单位之前的记录）时不做校验
+// 返回订单生效的单位代码，修改订单未传 unit 时取原订单的单位
+func checkOrderUnit(stub shim.ChaincodeStubInterface, orderMap map[string]interface{}, defaultUnit bool) (string, error) {
+	unitCode, hasUnit := orderMap["unit"].(string)
+	stockId, hasStockId := orderMap["goodsStockId"].(string)
+	_, hasWeight := orderMap["weight"].(string)
+	if !defaultUnit && (hasUnit != hasStockId || (!hasUnit && hasWeight)) {
+		orderNo, _ := orderMap["orderNo"].(string)
+		res := stub.InvokeChaincode(orderContractName, [][]byte{[]byte("queryOrderByOrderNo"), []byte(orderNo)}, stub.GetChannelID())
+		if res.Status != shim.OK {
+			return "", fmt.Errorf("failed to query order %s: %s", orderNo, res.Message)
+		}
+		existing := make(map[string]interface{})
+		err := json.Unmarshal(res.Payload, &existing)
+		if err != nil {
+			return "", fmt.Errorf("failed to unmarshal order: %s", err.Error())
+		}
+		if !hasUnit {
+			unitCode, _ = existing["unit"].(string)
+		}
+		if !hasStockId {
+			stockId, _ = existing["goodsStockId"].(string)
+		}
+	}
+	orderUnit, err := lookupUnit(stub, unitCode)
+	if err != nil {
+		return "", err
+	}
+	if stockId == "" {
+		return unitCode, nil
+	}
+	goods, err := getGoods(stub, stockId)
+	if err != nil {
+		return "", err
+	}
+	if goods == nil {
+		return unitCode, nil
+	}
+	goodsUnit, err := lookupUnit(stub, goods.Unit)
+	if err != nil {
+		return "", err
+	}
+	if orderUnit == nil && goodsUnit != nil && defaultUnit {
+		orderMap["unit"] = goods.Unit
+		return goods.Unit, nil
+	}
+	return unitCode, checkUnitsCompatible(orderUnit, goodsUnit)
+}
+
+func orderArgs(fn string, args []string) [][]byte {
+	invokeArgs := [][]byte{[]byte(fn)}
+	for _, arg := range args {
+		invokeArgs = append(invokeArgs, []byte(arg))
+	}
+	return invokeArgs
+}
+
 func queryOrder(stub shim.ChaincodeStubInterface, args []string) peer.Response {
 	res := stub.InvokeChaincode(orderContractName, [][]byte{[]byte("queryOrder"), []byte(args[0])}, stub.GetChannelID())
 	if res.Status != shim.OK {
@@ -551,6 +863,60 @@ func queryOrder(stub shim.ChaincodeStubInterface, args []string) peer.Response {
 	return shim.Success(res.Payload)
 }
 
+func getCaller(stub shim.ChaincodeStubInterface) (*Caller, error) {
+	mspId, err := cid.GetMSPID(stub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller msp id: %s", err.Error())
+	}
+	cert, err := cid.GetX509Certificate(stub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller certificate: %s", err.Error())
+	}
+	return &Caller{MSPID: mspId, Subject: cert.Subject.String()}, nil
+}
+
+// 根据 stockId 获取 goods，不存在时返回 nil
+func getGoods(stub shim.ChaincodeStubInterface, stockId string) (*Goods, error) {
+	jsonVal, err := stub.GetState(stockId)
+	if err != nil {
+		return nil, err
+	}
+	if jsonVal == nil {
+		return nil, nil
+	}
+	goods := Goods{}
+	err = json.Unmarshal(jsonVal, &goods)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal goods %s: %s", stockId, err.Error())
+	}
+	return &goods, nil
+}
+
+// 调用方持有读取时的 goods，写入时版本加 1
+func putGoods(stub shim.ChaincodeStubInterface, goods *Goods) error {
+	goods.Version++
+	jsonVal, err := json.Marshal(goods)
+	if err != nil {
+		return fmt.Errorf("failed to marshal goods: %s", err.Error())
+	}
+	return stub.PutState(goods.StockId, jsonVal)
+}
+
+// 校验调用方传入的 expectedVersion，为空时不校验；引入版本之前写入的记录版本为 0
+func checkVersion(id string, version int64, expectedVersion string) error {
+	if expectedVersion == "" {
+		return nil
+	}
+	expected, err := strconv.ParseInt(expectedVersion, 10, 64)
+	if err != nil {
+		return fmt.Errorf("expectedVersion should be an integer, get %s", expectedVersion)
+	}
+	if expected != version {
+		return fmt.Errorf("version conflict: %s is at version %d, expected %d", id, version, expected)
+	}
+	return nil
+}
+
 func constructQueryResponseFromIterator(resultsIterator shim.StateQueryIteratorInterface, bookmark string) (*bytes.Buffer, error) {
 	var buffer bytes.Buffer
 	buffer.WriteString("{\"data\":[")
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/msp"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// MockInvoke 使用当前时间作为交易时间且不支持 GetCreator，identityStub 用于指定调用者身份
+type identityStub struct {
+	*shim.MockStub
+	args    []string
+	creator []byte
+}
+
+func (stub *identityStub) GetFunctionAndParameters() (string, []string) {
+	return stub.args[0], stub.args[1:]
+}
+
+func (stub *identityStub) GetCreator() ([]byte, error) {
+	return stub.creator, nil
+}
+
+// 生成自签名证书作为调用者身份，attrs 按 fabric-ca 的格式写入证书扩展
+func newCreator(mspId string, commonName string, attrs map[string]string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{mspId}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if attrs != nil {
+		value, _ := json.Marshal(map[string]interface{}{"attrs": attrs})
+		template.ExtraExtensions = []pkix.Extension{{Id: asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}, Value: value}}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	creator, err := proto.Marshal(&msp.SerializedIdentity{
+		Mspid:   mspId,
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	})
+	if err != nil {
+		panic(err)
+	}
+	return creator
+}
+
+func invokeAs(mockStub *shim.MockStub, creator []byte, txTime time.Time, txId string, args ...string) peer.Response {
+	mockStub.MockTransactionStart(txId)
+	defer mockStub.MockTransactionEnd(txId)
+	mockStub.TxTimestamp.Seconds, mockStub.TxTimestamp.Nanos = txTime.Unix(), 0
+	return GoodsContract{}.Invoke(&identityStub{MockStub: mockStub, args: args, creator: creator})
+}
+
+// 不关心调用者身份时使用的普通用户
+var defaultCreator = newCreator("Org1MSP", "user1", nil)
+
+func invokeAt(mockStub *shim.MockStub, txTime time.Time, txId string, args ...string) peer.Response {
+	return invokeAs(mockStub, defaultCreator, txTime, txId, args...)
+}
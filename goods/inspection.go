@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	inspectionObjectType = "inspection"
+
+	QcUntested     = "0"
+	QcPass         = "1"
+	QcFail         = "2"
+	QcRetestPass   = "3"
+	QcRetestFail   = "4"
+	InspectionPass = "pass"
+	InspectionFail = "fail"
+)
+
+var reportHashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// 检测结论驱动 QcStatus 的合法变化：未检测后为合格或不合格，不合格后可复检；合格、复检后不能再提交
+var qcTransitions = map[string]map[string]string{
+	QcUntested: {InspectionPass: QcPass, InspectionFail: QcFail},
+	QcFail:     {InspectionPass: QcRetestPass, InspectionFail: QcRetestFail},
+}
+
+type Inspection struct {
+	InspectionId string  `json:"inspectionId"` // 检测报告编号，同一 stockId 下唯一
+	StockId      string  `json:"stockId"`      // 商品库存编号
+	Lab          string  `json:"lab"`          // 检测机构
+	Method       string  `json:"method"`       // 检测方法
+	Results      string  `json:"results"`      // 检测项目及结果
+	ReportHash   string  `json:"reportHash"`   // 检测报告文件的 sha256，小写十六进制
+	Result       string  `json:"result"`       // 结论 pass 或 fail
+	FromStatus   string  `json:"fromStatus"`   // 检测前的 QcStatus
+	ToStatus     string  `json:"toStatus"`     // 检测后的 QcStatus
+	Inspector    *Caller `json:"inspector"`    // 提交报告的检测员，取调用者身份
+	InspectTime  string  `json:"inspectTime"`  // 交易时间
+	TxId         string  `json:"txId"`
+}
+
+// 检测员提交检测报告，按结论修改 QcStatus；不合格的商品自动下架且不能上架，复检合格后可重新上架
+// 需由证书属性 inspector=true 的身份调用
+// value string required {"inspectionId","stockId","lab","method","results","reportHash","result"}
+// expectedVersion string 可选，与 goods 当前版本不一致时报错
+func submitInspection(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("args length should be 1 or 2")
+	}
+	expectedVersion := ""
+	if len(args) == 2 {
+		expectedVersion = args[1]
+	}
+	err := cid.AssertAttributeValue(stub, "inspector", "true")
+	if err != nil {
+		return shim.Error("only inspector can do this: " + err.Error())
+	}
+	inspection := Inspection{}
+	err = json.Unmarshal([]byte(args[0]), &inspection)
+	if err != nil {
+		return shim.Error("unmarshal inspection failed" + err.Error())
+	}
+	if inspection.InspectionId == "" || inspection.StockId == "" || inspection.Lab == "" || inspection.Method == "" {
+		return shim.Error("inspectionId, stockId, lab and method is required")
+	}
+	if !reportHashPattern.MatchString(inspection.ReportHash) {
+		return shim.Error("reportHash should be a lowercase hex sha256, get " + inspection.ReportHash)
+	}
+	if inspection.Result != InspectionPass && inspection.Result != InspectionFail {
+		return shim.Error("result should be pass or fail, get " + inspection.Result)
+	}
+
+	existing, err := getInspection(stub, inspection.StockId, inspection.InspectionId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if existing != nil {
+		return shim.Error("inspection " + inspection.InspectionId + " already exists")
+	}
+	goods, err := getGoods(stub, inspection.StockId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if goods == nil {
+		return shim.Error(ErrorNotFound)
+	}
+	err = checkVersion(goods.StockId, goods.Version, expectedVersion)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fromStatus := qcStatus(goods)
+	toStatus, ok := qcTransitions[fromStatus][inspection.Result]
+	if !ok {
+		return shim.Error(fmt.Sprintf("goods %s with qcstatus %s can not be inspected again", goods.StockId, fromStatus))
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	inspection.Inspector, err = getCaller(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	inspection.FromStatus = fromStatus
+	inspection.ToStatus = toStatus
+	inspection.InspectTime = txTime.Format(time.RFC3339)
+	inspection.TxId = stub.GetTxID()
+	err = putInspection(stub, &inspection)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	goods.QcStatus = toStatus
+	fromGsiStatus := goods.GsiStatus
+	if qcFailed(goods) {
+		goods.GsiStatus = GsiUnlisted
+	}
+	err = putGoods(stub, goods)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = addListingChange(stub, goods.StockId, fromGsiStatus, goods.GsiStatus, "quality inspection "+inspection.InspectionId+" failed")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(stub.GetTxID()))
+}
+
+// 查询 stockId 的全部检测记录，按检测时间排序
+// stockId string required
+// res : [Inspection]
+func queryInspections(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("args length should be 1")
+	}
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(inspectionObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	data := make([]Inspection, 0)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error("failed get resultsIterator:" + err.Error())
+		}
+		inspection := Inspection{}
+		err = json.Unmarshal(queryResponse.Value, &inspection)
+		if err != nil {
+			return shim.Error("failed to unmarshal inspection:" + err.Error())
+		}
+		data = append(data, inspection)
+	}
+	// 同一 stockId 的检测只能依次进行，检测前状态可以确定先后
+	sort.SliceStable(data, func(i, j int) bool {
+		return data[i].FromStatus < data[j].FromStatus
+	})
+	res, err := json.Marshal(&data)
+	if err != nil {
+		return shim.Error("failed to marshal res" + err.Error())
+	}
+	return shim.Success(res)
+}
+
+// 引入检测记录之前写入的 goods 可能没有 qcstatus，视为未检测
+func qcStatus(goods *Goods) string {
+	if goods.QcStatus == "" {
+		return QcUntested
+	}
+	return goods.QcStatus
+}
+
+func qcFailed(goods *Goods) bool {
+	return goods.QcStatus == QcFail || goods.QcStatus == QcRetestFail
+}
+
+func getInspection(stub shim.ChaincodeStubInterface, stockId string, inspectionId string) (*Inspection, error) {
+	key, err := stub.CreateCompositeKey(inspectionObjectType, []string{stockId, inspectionId})
+	if err != nil {
+		return nil, err
+	}
+	jsonVal, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if jsonVal == nil {
+		return nil, nil
+	}
+	inspection := Inspection{}
+	err = json.Unmarshal(jsonVal, &inspection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal inspection %s: %s", inspectionId, err.Error())
+	}
+	return &inspection, nil
+}
+
+func putInspection(stub shim.ChaincodeStubInterface, inspection *Inspection) error {
+	key, err := stub.CreateCompositeKey(inspectionObjectType, []string{inspection.StockId, inspection.InspectionId})
+	if err != nil {
+		return err
+	}
+	jsonVal, err := json.Marshal(inspection)
+	if err != nil {
+		return fmt.Errorf("failed to marshal inspection: %s", err.Error())
+	}
+	return stub.PutState(key, jsonVal)
+}
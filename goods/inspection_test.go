@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestInspection(t *testing.T) {
+	mockStub := shim.NewMockStub("goods", new(GoodsContract))
+	now := time.Now()
+	if res := invokeAt(mockStub, now, "tx1", "addGoods", `{"stockId":"s1","stockNum":"10","gsiStatus":"0","qcstatus":"3"}`); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if goods, _ := getGoods(mockStub, "s1"); goods.QcStatus != QcUntested {
+		t.Fatal("qcstatus should only be changed by inspections")
+	}
+
+	inspector := newCreator("LabMSP", "inspector1", map[string]string{"inspector": "true"})
+	inspect := func(txId string, id string, result string) string {
+		value := `{"inspectionId":"` + id + `","stockId":"s1","lab":"lab","method":"GB 2763","results":"ok","reportHash":"` +
+			strings.Repeat("a", 64) + `","result":"` + result + `"}`
+		return invokeAs(mockStub, inspector, now, txId, "submitInspection", value).Message
+	}
+	if res := invokeAs(mockStub, newCreator("Org1MSP", "user", nil), now, "tx2", "submitInspection", `{}`); res.Status == shim.OK {
+		t.Fatal("only inspector can submit inspections")
+	}
+	if message := inspect("tx3", "i1", InspectionFail); message != "" {
+		t.Fatal(message)
+	}
+	goods, _ := getGoods(mockStub, "s1")
+	if goods.QcStatus != QcFail || goods.GsiStatus != GsiUnlisted {
+		t.Fatal(goods)
+	}
+	if res := invokeAt(mockStub, now, "tx4", "updateGoodStatus", "s1", GsiListed); res.Status == shim.OK {
+		t.Fatal("failed goods should not be listed")
+	}
+	reservation := func(id string) string {
+		return `{"reservationId":"` + id + `","stockId":"s1","buyer":"b1","quantity":"1","expireTime":"` + now.Add(time.Hour).Format(time.RFC3339) + `"}`
+	}
+	if res := invokeAt(mockStub, now, "tx4", "reserveGoods", reservation("r1")); res.Status == shim.OK {
+		t.Fatal("failed goods should not be reserved")
+	}
+	if res := invokeAt(mockStub, now, "tx4", "addOrder", `{"orderNo":"o1","goodsStockId":"s1","weight":"1"}`); res.Status == shim.OK {
+		t.Fatal("failed goods should not be ordered")
+	}
+	if message := inspect("tx5", "i2", InspectionPass); message != "" {
+		t.Fatal(message)
+	}
+	if message := inspect("tx6", "i3", InspectionPass); message == "" {
+		t.Fatal("re-tested goods should not be inspected again")
+	}
+	if res := invokeAt(mockStub, now, "tx7", "updateGoodStatus", "s1", GsiListed); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if res := invokeAt(mockStub, now, "tx8", "reserveGoods", reservation("r2")); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+
+	res := invokeAt(mockStub, now, "query", "queryInspections", "s1")
+	inspections := make([]Inspection, 0)
+	_ = json.Unmarshal(res.Payload, &inspections)
+	if len(inspections) != 2 || inspections[0].ToStatus != QcFail || inspections[1].ToStatus != QcRetestPass || inspections[1].Inspector.MSPID != "LabMSP" {
+		t.Fatal(string(res.Payload))
+	}
+}
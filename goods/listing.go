@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	listingObjectType = "listing"
+
+	GsiListed   = "0" // 上架
+	GsiUnlisted = "1" // 下架
+
+	// 定长的时间格式，按 key 排序即为时间顺序
+	sortableTimeLayout = "2006-01-02T15:04:05.000000000Z"
+)
+
+type ListingChange struct {
+	StockId    string  `json:"stockId"`
+	FromStatus string  `json:"fromStatus"` // 修改前的 gsiStatus，新增时为空
+	ToStatus   string  `json:"toStatus"`   // 修改后的 gsiStatus
+	Reason     string  `json:"reason"`     // 原因
+	Operator   *Caller `json:"operator"`   // 调用者身份
+	Time       string  `json:"time"`       // 交易时间
+	TxId       string  `json:"txId"`
+}
+
+// 查询 stockId 的上下架记录，按时间排序
+// stockId string required
+// res : [ListingChange]
+func queryListingTimeline(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("args length should be 1")
+	}
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(listingObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	data := make([]ListingChange, 0)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error("failed get resultsIterator:" + err.Error())
+		}
+		change := ListingChange{}
+		err = json.Unmarshal(queryResponse.Value, &change)
+		if err != nil {
+			return shim.Error("failed to unmarshal listing change:" + err.Error())
+		}
+		data = append(data, change)
+	}
+	res, err := json.Marshal(&data)
+	if err != nil {
+		return shim.Error("failed to marshal res" + err.Error())
+	}
+	return shim.Success(res)
+}
+
+func isGsiStatus(gsiStatus string) bool {
+	return gsiStatus == GsiListed || gsiStatus == GsiUnlisted
+}
+
+// 检测不合格或已下架的商品不能预留和下单；gsiStatus 为空的是引入上下架状态之前的商品，视为上架
+func checkSellable(goods *Goods) error {
+	if qcFailed(goods) {
+		return fmt.Errorf("goods %s failed quality inspection and can not be sold", goods.StockId)
+	}
+	if goods.GsiStatus == GsiUnlisted {
+		return fmt.Errorf("goods %s is unlisted and can not be sold", goods.StockId)
+	}
+	return nil
+}
+
+// 下架前检查未过期的预留和未完成的订单
+func checkUnlisting(stub shim.ChaincodeStubInterface, goods *Goods) error {
+	err := checkNoReservations(stub, goods)
+	if err != nil {
+		return err
+	}
+	res := stub.InvokeChaincode(orderContractName, [][]byte{[]byte("queryOpenOrders"), []byte(goods.StockId)}, stub.GetChannelID())
+	if res.Status != shim.OK {
+		return fmt.Errorf("failed to query open orders: %s", res.Message)
+	}
+	orders := make([]struct {
+		OrderNo string `json:"orderNo"`
+		Status  string `json:"status"`
+	}, 0)
+	err = json.Unmarshal(res.Payload, &orders)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal open orders: %s", err.Error())
+	}
+	// 没有状态的旧订单无法判断是否完成，需先通过 updateOrder 设置明确的状态
+	open, legacy := 0, make([]string, 0)
+	for _, order := range orders {
+		if order.Status == "" {
+			legacy = append(legacy, order.OrderNo)
+		} else {
+			open++
+		}
+	}
+	if open > 0 {
+		return fmt.Errorf("goods %s has %d open orders, complete or cancel them before unlisting", goods.StockId, open)
+	}
+	if len(legacy) > 0 {
+		return fmt.Errorf("goods %s has orders without status: %s, set their status to completed or cancelled before unlisting", goods.StockId, strings.Join(legacy, ", "))
+	}
+	return nil
+}
+
+// 记录上下架状态的变化，状态不变时不记录
+func addListingChange(stub shim.ChaincodeStubInterface, stockId string, fromStatus string, toStatus string, reason string) error {
+	if fromStatus == toStatus {
+		return nil
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+	operator, err := getCaller(stub)
+	if err != nil {
+		return err
+	}
+	change := ListingChange{
+		StockId:    stockId,
+		FromStatus: fromStatus,
+		ToStatus:   toStatus,
+		Reason:     reason,
+		Operator:   operator,
+		Time:       txTime.Format(time.RFC3339Nano),
+		TxId:       stub.GetTxID(),
+	}
+	key, err := stub.CreateCompositeKey(listingObjectType, []string{stockId, txTime.Format(sortableTimeLayout), change.TxId})
+	if err != nil {
+		return err
+	}
+	jsonVal, err := json.Marshal(&change)
+	if err != nil {
+		return fmt.Errorf("failed to marshal listing change: %s", err.Error())
+	}
+	return stub.PutState(key, jsonVal)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// MockStub 不支持 rich query，用固定结果代替 order 链码；addOrder、updateOrder 记录收到的订单
+type fakeOrderContract struct {
+	openOrders map[string]string
+	orders     map[string]string
+	received   []string
+}
+
+func (t *fakeOrderContract) Init(stub shim.ChaincodeStubInterface) peer.Response {
+	return shim.Success(nil)
+}
+
+func (t *fakeOrderContract) Invoke(stub shim.ChaincodeStubInterface) peer.Response {
+	fn, args := stub.GetFunctionAndParameters()
+	switch fn {
+	case "queryOpenOrders":
+		if orders, ok := t.openOrders[args[0]]; ok {
+			return shim.Success([]byte(orders))
+		}
+		return shim.Success([]byte("[]"))
+	case "queryOrderByOrderNo":
+		if order, ok := t.orders[args[0]]; ok {
+			return shim.Success([]byte(order))
+		}
+		return shim.Error(ErrorNotFound)
+	case "addOrder", "updateOrder":
+		t.received = append(t.received, args[0])
+		return shim.Success(nil)
+	default:
+		return shim.Error("unexpected function " + fn)
+	}
+}
+
+func TestListingTimeline(t *testing.T) {
+	mockStub := shim.NewMockStub("goods", new(GoodsContract))
+	orders := &fakeOrderContract{openOrders: map[string]string{}}
+	mockStub.MockPeerChaincode(orderContractName, shim.NewMockStub(orderContractName, orders))
+	now := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+
+	if res := invokeAt(mockStub, now, "tx1", "addGoods", `{"stockId":"s1","stockNum":"10","gsiStatus":"0"}`); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if res := invokeAt(mockStub, now, "tx2", "updateGoodStatus", "s1", "2"); res.Status == shim.OK {
+		t.Fatal("invalid gsiStatus should be rejected")
+	}
+	if res := invokeAt(mockStub, now, "tx3", "updateGoodStatus", "s1", GsiListed); res.Status == shim.OK {
+		t.Fatal("unchanged gsiStatus should be rejected")
+	}
+
+	// 有未过期的预留或未完成的订单时不能下架
+	value := `{"reservationId":"r1","stockId":"s1","buyer":"b1","quantity":"1","expireTime":"` + now.Add(time.Hour).Format(time.RFC3339) + `"}`
+	if res := invokeAt(mockStub, now, "tx4", "reserveGoods", value); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if res := invokeAt(mockStub, now, "tx5", "updateGoodStatus", "s1", GsiUnlisted); res.Status == shim.OK {
+		t.Fatal("goods with active reservations should not be unlisted")
+	}
+	if res := invokeAt(mockStub, now, "tx6", "releaseReservation", "s1", "r1"); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	orders.openOrders["s1"] = `[{"orderNo":"o1","goodsStockId":"s1","status":"open"}]`
+	if res := invokeAt(mockStub, now, "tx7", "updateGoodStatus", "s1", GsiUnlisted); res.Status == shim.OK {
+		t.Fatal("goods with open orders should not be unlisted")
+	}
+	// 没有状态的旧订单无法判断是否完成，同样不能下架
+	orders.openOrders["s1"] = `[{"orderNo":"o0","goodsStockId":"s1","status":""}]`
+	res := invokeAt(mockStub, now, "tx7", "updateGoodStatus", "s1", GsiUnlisted)
+	if res.Status == shim.OK || !strings.Contains(res.Message, "without status: o0") {
+		t.Fatal("goods with orders without status should not be unlisted", res.Message)
+	}
+	delete(orders.openOrders, "s1")
+
+	later := now.Add(time.Minute)
+	if res := invokeAt(mockStub, later, "tx8", "updateGoodStatus", "s1", GsiUnlisted, "", "out of season"); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if res := invokeAt(mockStub, later.Add(time.Minute), "tx9", "updateGoodStatus", "s1", GsiListed); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+
+	res = invokeAt(mockStub, later, "query", "queryListingTimeline", "s1")
+	timeline := make([]ListingChange, 0)
+	_ = json.Unmarshal(res.Payload, &timeline)
+	if len(timeline) != 3 {
+		t.Fatal(string(res.Payload))
+	}
+	if timeline[0].FromStatus != "" || timeline[0].ToStatus != GsiListed || timeline[0].TxId != "tx1" {
+		t.Fatal(timeline[0])
+	}
+	if timeline[1].ToStatus != GsiUnlisted || timeline[1].Reason != "out of season" || timeline[1].Operator.MSPID != "Org1MSP" {
+		t.Fatal(timeline[1])
+	}
+	if timeline[2].FromStatus != GsiUnlisted || timeline[2].ToStatus != GsiListed {
+		t.Fatal(timeline[2])
+	}
+}
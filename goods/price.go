@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// 价格变化按 stockId 记录一份，另按 goodsId、marketName、日期记录一份用于统计走势
+const (
+	priceChangeObjectType = "priceChange"
+	priceTrendObjectType  = "priceTrend"
+
+	dateLayout        = "2006-01-02"
+	maxPriceTrendDays = 366
+)
+
+type PriceChange struct {
+	StockId    string  `json:"stockId"`
+	GoodsId    string  `json:"goodsId"`
+	MarketName string  `json:"marketName"`
+	OldPrice   string  `json:"oldPrice"` // 修改前的单价，新增时为空
+	NewPrice   string  `json:"newPrice"` // 修改后的单价
+	Unit       string  `json:"unit"`     // 单价的计量单位，即 goods 的单位
+	Caller     *Caller `json:"caller"`   // 调用者身份
+	Time       string  `json:"time"`     // 交易时间
+	TxId       string  `json:"txId"`
+}
+
+// 一天内价格变化的统计，只统计当天设置的新价格；单价换算为每基本单位的价格
+type DailyPrice struct {
+	Date  string `json:"date"` // UTC 日期
+	Unit  string `json:"unit"` // 基本单位，如 kg；引入单位之前的记录为空
+	Min   string `json:"min"`
+	Avg   string `json:"avg"` // 按金额小数位数四舍五入
+	Max   string `json:"max"`
+	Count int    `json:"count"` // 当天价格变化的次数
+}
+
+// 查询 stockId 的价格变化记录，按时间排序
+// stockId string required
+// res : [PriceChange]
+func queryPriceHistory(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("args length should be 1")
+	}
+	changes, err := getPriceChanges(stub, priceChangeObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	res, err := json.Marshal(&changes)
+	if err != nil {
+		return shim.Error("failed to marshal res" + err.Error())
+	}
+	return shim.Success(res)
+}
+
+// 按天统计 goodsId 在 marketName 的最低、平均、最高价，没有价格变化的日期不返回
+// 不同单位的单价换算为基本单位后统计，量纲不同或部分记录没有单位时报错
+// goodsId string required
+// marketName string required
+// startDate string required 格式 2006-01-02，按 UTC 计算
+// endDate string required 包含当天，与 startDate 相差不超过 366 天
+// res : [DailyPrice]
+func queryPriceTrend(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 4 {
+		return shim.Error("args length should be 4")
+	}
+	goodsId, marketName := args[0], args[1]
+	if goodsId == "" || marketName == "" {
+		return shim.Error("goodsId and marketName is required")
+	}
+	startDate, err := time.Parse(dateLayout, args[2])
+	if err != nil {
+		return shim.Error("failed to parse startDate " + args[2])
+	}
+	endDate, err := time.Parse(dateLayout, args[3])
+	if err != nil {
+		return shim.Error("failed to parse endDate " + args[3])
+	}
+	if endDate.Before(startDate) {
+		return shim.Error("endDate should not be before startDate")
+	}
+	if endDate.Sub(startDate) >= maxPriceTrendDays*24*time.Hour {
+		return shim.Error(fmt.Sprintf("date range should be at most %d days", maxPriceTrendDays))
+	}
+	scales, err := getDecimalScales(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	data := make([]DailyPrice, 0)
+	var baseUnit *Unit
+	for date := startDate; !date.After(endDate); date = date.AddDate(0, 0, 1) {
+		day := date.Format(dateLayout)
+		changes, err := getPriceChanges(stub, priceTrendObjectType, []string{goodsId, marketName, day})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		var min, max, sum int64
+		count := 0
+		for _, change := range changes {
+			if change.NewPrice == "" {
+				continue
+			}
+			units, err := parseStoredDecimal("price", change.NewPrice, scales.Money)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			unit, err := lookupUnit(stub, change.Unit)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			changeBase := unit
+			if unit != nil {
+				changeBase = baseUnits[unit.Dimension]
+			}
+			if len(data) == 0 && count == 0 {
+				baseUnit = changeBase
+			} else if changeBase != baseUnit {
+				return shim.Error(fmt.Sprintf("prices of goods %s in %s have incompatible units", goodsId, marketName))
+			}
+			units, err = pricePerBaseUnit(units, unit)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			if count == 0 || units < min {
+				min = units
+			}
+			if count == 0 || units > max {
+				max = units
+			}
+			sum += units
+			count++
+		}
+		if count == 0 {
+			continue
+		}
+		avg := (2*sum + int64(count)) / int64(2*count)
+		unitCode := ""
+		if baseUnit != nil {
+			unitCode = baseUnit.Code
+		}
+		data = append(data, DailyPrice{
+			Date:  day,
+			Unit:  unitCode,
+			Min:   formatDecimal(min, scales.Money),
+			Avg:   formatDecimal(avg, scales.Money),
+			Max:   formatDecimal(max, scales.Money),
+			Count: count,
+		})
+	}
+	res, err := json.Marshal(&data)
+	if err != nil {
+		return shim.Error("failed to marshal res" + err.Error())
+	}
+	return shim.Success(res)
+}
+
+// 记录单价的变化，单价和单位都不变时不记录
+func addPriceChange(stub shim.ChaincodeStubInterface, goods *Goods, oldPrice string, oldUnit string) error {
+	if oldPrice == goods.Price && oldUnit == goods.Unit {
+		return nil
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return err
+	}
+	change := PriceChange{
+		StockId:    goods.StockId,
+		GoodsId:    goods.GoodsId,
+		MarketName: goods.MarketName,
+		OldPrice:   oldPrice,
+		NewPrice:   goods.Price,
+		Unit:       goods.Unit,
+		Caller:     caller,
+		Time:       txTime.Format(time.RFC3339Nano),
+		TxId:       stub.GetTxID(),
+	}
+	jsonVal, err := json.Marshal(&change)
+	if err != nil {
+		return fmt.Errorf("failed to marshal price change: %s", err.Error())
+	}
+	sortableTime := txTime.Format(sortableTimeLayout)
+	key, err := stub.CreateCompositeKey(priceChangeObjectType, []string{change.StockId, sortableTime, change.TxId})
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(key, jsonVal)
+	if err != nil {
+		return err
+	}
+	if change.GoodsId == "" || change.MarketName == "" {
+		return nil
+	}
+	trendKey, err := stub.CreateCompositeKey(priceTrendObjectType, []string{change.GoodsId, change.MarketName, txTime.Format(dateLayout), sortableTime, change.StockId, change.TxId})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(trendKey, jsonVal)
+}
+
+func getPriceChanges(stub shim.ChaincodeStubInterface, objectType string, attributes []string) ([]PriceChange, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	changes := make([]PriceChange, 0)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed get resultsIterator: %s", err.Error())
+		}
+		change := PriceChange{}
+		err = json.Unmarshal(queryResponse.Value, &change)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal price change: %s", err.Error())
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
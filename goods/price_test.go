@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestPriceChanges(t *testing.T) {
+	mockStub := shim.NewMockStub("goods", new(GoodsContract))
+	day1 := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	if res := invokeAt(mockStub, day1, "tx1", "addGoods", `{"stockId":"s1","goodsId":"g1","marketName":"m1","price":"10"}`); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if res := invokeAt(mockStub, day1, "tx2", "addGoods", `{"stockId":"s2","goodsId":"g1","marketName":"m1","price":"12.5"}`); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	setPrice := func(txTime time.Time, txId string, stockId string, price string) {
+		if res := invokeAt(mockStub, txTime, txId, "updateGoodsStockFileNameOrPrice", stockId, "", price); res.Status != shim.OK {
+			t.Fatal(res.Message)
+		}
+	}
+	setPrice(day1.Add(time.Hour), "tx3", "s1", "11")
+	setPrice(day1.Add(2*time.Hour), "tx4", "s1", "11.00") // 价格不变，不记录
+	setPrice(day2, "tx5", "s1", "9")
+
+	res := invokeAt(mockStub, day2, "query", "queryPriceHistory", "s1")
+	history := make([]PriceChange, 0)
+	_ = json.Unmarshal(res.Payload, &history)
+	if len(history) != 3 {
+		t.Fatal(string(res.Payload))
+	}
+	if history[0].OldPrice != "" || history[0].NewPrice != "10.00" || history[2].OldPrice != "11.00" || history[2].NewPrice != "9.00" {
+		t.Fatal(string(res.Payload))
+	}
+	if history[1].Caller.MSPID != "Org1MSP" || history[1].TxId != "tx3" {
+		t.Fatal(history[1])
+	}
+
+	if res := invokeAt(mockStub, day2, "query", "queryPriceTrend", "g1", "m1", "2024-05-02", "2024-05-01"); res.Status == shim.OK {
+		t.Fatal("endDate before startDate should be rejected")
+	}
+	res = invokeAt(mockStub, day2, "query", "queryPriceTrend", "g1", "m1", "2024-04-30", "2024-05-03")
+	trend := make([]DailyPrice, 0)
+	_ = json.Unmarshal(res.Payload, &trend)
+	if len(trend) != 2 {
+		t.Fatal(string(res.Payload))
+	}
+	// 5 月 1 日的价格为 10、12.5、11
+	if trend[0] != (DailyPrice{Date: "2024-05-01", Min: "10.00", Avg: "11.17", Max: "12.50", Count: 3}) {
+		t.Fatal(trend[0])
+	}
+	if trend[1] != (DailyPrice{Date: "2024-05-02", Min: "9.00", Avg: "9.00", Max: "9.00", Count: 1}) {
+		t.Fatal(trend[1])
+	}
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	reservationObjectType       = "reservation"
+	activeReservationObjectType = "activeReservation"
+
+	ReservationHeld      = "held"      // 预留中，过期后不再占用库存
+	ReservationConfirmed = "confirmed" // 已确认，库存已扣减
+	ReservationReleased  = "released"  // 已释放
+)
+
+type Reservation struct {
+	ReservationId string `json:"reservationId"` // 预留编号，同一 stockId 下唯一
+	StockId       string `json:"stockId"`       // 商品库存编号
+	Buyer         string `json:"buyer"`         // 买家
+	Quantity      string `json:"quantity"`      // 预留数量
+	Unit          string `json:"unit"`          // 传入时为 quantity 的单位，保存时换算为 goods 的单位
+	ExpireTime    string `json:"expireTime"`    // 过期时间，RFC3339
+	Status        string `json:"status"`        // held、confirmed 或 released
+	CreateTime    string `json:"createTime"`    // 预留时的交易时间
+	UpdateTime    string `json:"updateTime"`    // 最后一次修改的交易时间
+	TxId          string `json:"txId"`          // 最后一次修改的交易 id
+	Version       int64  `json:"version"`       // 版本，每次写入加 1，不需要传入
+}
+
+type AvailableStock struct {
+	StockId   string `json:"stockId"`
+	StockNum  string `json:"stockNum"`  // 库存数量
+	Unit      string `json:"unit"`      // goods 的计量单位
+	Reserved  string `json:"reserved"`  // 未过期的预留数量
+	Available string `json:"available"` // 可售数量
+}
+
+// 预留库存，可售数量不足时报错；预留期间由链下完成支付，再确认或释放
+// value string required {"reservationId","stockId","buyer","quantity","expireTime"}，可选 unit，与 goods 的单位不同时换算
+func reserveGoods(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("args length should be 1")
+	}
+	reservation := Reservation{}
+	err := json.Unmarshal([]byte(args[0]), &reservation)
+	if err != nil {
+		return shim.Error("unmarshal reservation failed" + err.Error())
+	}
+	if reservation.ReservationId == "" || reservation.StockId == "" || reservation.Buyer == "" {
+		return shim.Error("reservationId, stockId and buyer is required")
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	expireTime, err := time.Parse(time.RFC3339, reservation.ExpireTime)
+	if err != nil {
+		return shim.Error("expireTime should be RFC3339, get " + reservation.ExpireTime)
+	}
+	if !expireTime.After(txTime) {
+		return shim.Error("expireTime should be later than the transaction time " + txTime.Format(time.RFC3339))
+	}
+
+	existing, err := getReservation(stub, reservation.StockId, reservation.ReservationId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if existing != nil {
+		return shim.Error("reservation " + reservation.ReservationId + " already exists")
+	}
+	goods, err := getGoods(stub, reservation.StockId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if goods == nil {
+		return shim.Error(ErrorNotFound)
+	}
+	err = checkSellable(goods)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	scales, err := getDecimalScales(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	scale := scales.quantityScale(goods.Unit)
+	quantity, err := parseDecimal("quantity", reservation.Quantity, scale)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if quantity == 0 {
+		return shim.Error("quantity should be greater than 0")
+	}
+	quantity, err = toGoodsUnits(stub, goods, quantity, scale, reservation.Unit)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	reservation.Quantity = formatDecimal(quantity, scale)
+	reservation.Unit = goods.Unit
+	stockNum, err := parseStoredDecimal("stockNum", goods.StockNum, scale)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	reserved, err := getReservedUnits(stub, goods.StockId, txTime, scale)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if quantity > stockNum-reserved {
+		return shim.Error(fmt.Sprintf("insufficient stock: %s available, %s requested", formatDecimal(stockNum-reserved, scale), reservation.Quantity))
+	}
+
+	reservation.Status = ReservationHeld
+	reservation.CreateTime = txTime.Format(time.RFC3339)
+	reservation.Version = 0
+	err = putReservation(stub, &reservation, txTime)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(stub.GetTxID()))
+}
+
+// 确认预留，按预留数量扣减库存；已过期的预留不能确认
+// stockId string required
+// reservationId string required
+// expectedVersion string 可选，与预留当前版本不一致时报错
+func confirmReservation(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	reservation, txTime, err := getHeldReservation(stub, args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if reservationExpired(reservation, txTime) {
+		return shim.Error("reservation " + reservation.ReservationId + " expired at " + reservation.ExpireTime)
+	}
+	goods, err := getGoods(stub, reservation.StockId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if goods == nil {
+		return shim.Error(ErrorNotFound)
+	}
+	scales, err := getDecimalScales(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	scale := scales.quantityScale(goods.Unit)
+	stockNum, err := parseStoredDecimal("stockNum", goods.StockNum, scale)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	quantity, err := parseDecimal("quantity", reservation.Quantity, scale)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if stockNum < quantity {
+		return shim.Error(fmt.Sprintf("insufficient stock: %s in stock, %s reserved", goods.StockNum, reservation.Quantity))
+	}
+	goods.StockNum = formatDecimal(stockNum-quantity, scale)
+	err = putGoods(stub, goods)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	reservation.Status = ReservationConfirmed
+	err = putReservation(stub, reservation, txTime)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(stub.GetTxID()))
+}
+
+// 释放预留，已过期的预留也可以释放
+// stockId string required
+// reservationId string required
+// expectedVersion string 可选，与预留当前版本不一致时报错
+func releaseReservation(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	reservation, txTime, err := getHeldReservation(stub, args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	reservation.Status = ReservationReleased
+	err = putReservation(stub, reservation, txTime)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(stub.GetTxID()))
+}
+
+// 查询 stockId 的全部预留，按 reservationId 排序
+// stockId string required
+// res : [Reservation]
+func queryReservations(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("args length should be 1")
+	}
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(reservationObjectType, []string{args[0]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	data := make([]Reservation, 0)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error("failed get resultsIterator:" + err.Error())
+		}
+		reservation := Reservation{}
+		err = json.Unmarshal(queryResponse.Value, &reservation)
+		if err != nil {
+			return shim.Error("failed to unmarshal reservation:" + err.Error())
+		}
+		data = append(data, reservation)
+	}
+	res, err := json.Marshal(&data)
+	if err != nil {
+		return shim.Error("failed to marshal res" + err.Error())
+	}
+	return shim.Success(res)
+}
+
+// 按交易时间查询可售数量，可售数量 = 库存数量 - 未过期的预留数量
+// stockId string required
+// res : AvailableStock
+func queryAvailableStock(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("args length should be 1")
+	}
+	goods, err := getGoods(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if goods == nil {
+		return shim.Error(ErrorNotFound)
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	scales, err := getDecimalScales(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	scale := scales.quantityScale(goods.Unit)
+	stock, err := getAvailableStock(stub, goods, txTime, scale)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	res, err := json.Marshal(stock)
+	if err != nil {
+		return shim.Error("failed to marshal res" + err.Error())
+	}
+	return shim.Success(res)
+}
+
+// 解析 confirm/release 的参数，返回预留中的记录
+func getHeldReservation(stub shim.ChaincodeStubInterface, args []string) (*Reservation, time.Time, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, time.Time{}, fmt.Errorf("args length should be 2 or 3")
+	}
+	stockId, reservationId := args[0], args[1]
+	expectedVersion := ""
+	if len(args) == 3 {
+		expectedVersion = args[2]
+	}
+	reservation, err := getReservation(stub, stockId, reservationId)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if reservation == nil {
+		return nil, time.Time{}, fmt.Errorf(ErrorNotFound)
+	}
+	err = checkVersion(reservationId, reservation.Version, expectedVersion)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if reservation.Status != ReservationHeld {
+		return nil, time.Time{}, fmt.Errorf("reservation %s is already %s", reservationId, reservation.Status)
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return reservation, txTime, nil
+}
+
+// 存在未过期的预留时报错，用于下架、修改单位等会影响预留的操作
+func checkNoReservations(stub shim.ChaincodeStubInterface, goods *Goods) error {
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+	scales, err := getDecimalScales(stub)
+	if err != nil {
+		return err
+	}
+	scale := scales.quantityScale(goods.Unit)
+	reserved, err := getReservedUnits(stub, goods.StockId, txTime, scale)
+	if err != nil {
+		return err
+	}
+	if reserved > 0 {
+		return fmt.Errorf("goods %s has %s reserved, confirm or release the reservations first", goods.StockId, formatDecimal(reserved, scale))
+	}
+	return nil
+}
+
+func getAvailableStock(stub shim.ChaincodeStubInterface, goods *Goods, txTime time.Time, scale int) (*AvailableStock, error) {
+	stockNum, err := parseStoredDecimal("stockNum", goods.StockNum, scale)
+	if err != nil {
+		return nil, err
+	}
+	reserved, err := getReservedUnits(stub, goods.StockId, txTime, scale)
+	if err != nil {
+		return nil, err
+	}
+	return &AvailableStock{
+		StockId:   goods.StockId,
+		StockNum:  formatDecimal(stockNum, scale),
+		Unit:      goods.Unit,
+		Reserved:  formatDecimal(reserved, scale),
+		Available: formatDecimal(stockNum-reserved, scale),
+	}, nil
+}
+
+// 汇总未过期的预留；预留中的记录另有 activeReservation 索引，避免扫描已结束的预留
+func getReservedUnits(stub shim.ChaincodeStubInterface, stockId string, txTime time.Time, scale int) (int64, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(activeReservationObjectType, []string{stockId})
+	if err != nil {
+		return 0, err
+	}
+	defer resultsIterator.Close()
+
+	reserved := int64(0)
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return 0, err
+		}
+		reservation := Reservation{}
+		err = json.Unmarshal(queryResponse.Value, &reservation)
+		if err != nil {
+			return 0, fmt.Errorf("failed to unmarshal reservation: %s", err.Error())
+		}
+		if reservationExpired(&reservation, txTime) {
+			continue
+		}
+		quantity, err := parseDecimal("quantity", reservation.Quantity, scale)
+		if err != nil {
+			return 0, err
+		}
+		reserved += quantity
+	}
+	return reserved, nil
+}
+
+func reservationExpired(reservation *Reservation, txTime time.Time) bool {
+	expireTime, err := time.Parse(time.RFC3339, reservation.ExpireTime)
+	return err != nil || !expireTime.After(txTime)
+}
+
+func getReservation(stub shim.ChaincodeStubInterface, stockId string, reservationId string) (*Reservation, error) {
+	key, err := stub.CreateCompositeKey(reservationObjectType, []string{stockId, reservationId})
+	if err != nil {
+		return nil, err
+	}
+	jsonVal, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if jsonVal == nil {
+		return nil, nil
+	}
+	reservation := Reservation{}
+	err = json.Unmarshal(jsonVal, &reservation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal reservation %s: %s", reservationId, err.Error())
+	}
+	return &reservation, nil
+}
+
+// 写入预留并维护 activeReservation 索引，预留中时索引保存完整记录，便于汇总时不再逐条读取
+func putReservation(stub shim.ChaincodeStubInterface, reservation *Reservation, txTime time.Time) error {
+	reservation.Version++
+	reservation.UpdateTime = txTime.Format(time.RFC3339)
+	reservation.TxId = stub.GetTxID()
+	jsonVal, err := json.Marshal(reservation)
+	if err != nil {
+		return fmt.Errorf("failed to marshal reservation: %s", err.Error())
+	}
+	attributes := []string{reservation.StockId, reservation.ReservationId}
+	key, err := stub.CreateCompositeKey(reservationObjectType, attributes)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(key, jsonVal)
+	if err != nil {
+		return err
+	}
+	activeKey, err := stub.CreateCompositeKey(activeReservationObjectType, attributes)
+	if err != nil {
+		return err
+	}
+	if reservation.Status == ReservationHeld {
+		return stub.PutState(activeKey, jsonVal)
+	}
+	return stub.DelState(activeKey)
+}
+
+// 交易时间，作为链码中的 "当前时间"，各背书节点一致
+func getTxTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
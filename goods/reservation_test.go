@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+func availableStock(t *testing.T, mockStub *shim.MockStub, txTime time.Time) AvailableStock {
+	res := invokeAt(mockStub, txTime, "query", "queryAvailableStock", "s1")
+	if res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	stock := AvailableStock{}
+	_ = json.Unmarshal(res.Payload, &stock)
+	return stock
+}
+
+func TestReservation(t *testing.T) {
+	mockStub := shim.NewMockStub("goods", new(GoodsContract))
+	now := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	if res := invokeAt(mockStub, now, "tx1", "addGoods", `{"stockId":"s1","stockNum":"10"}`); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	reserve := func(txId string, id string, quantity string, expire time.Time) peer.Response {
+		value := `{"reservationId":"` + id + `","stockId":"s1","buyer":"b1","quantity":"` + quantity + `","expireTime":"` + expire.Format(time.RFC3339) + `"}`
+		return invokeAt(mockStub, now, txId, "reserveGoods", value)
+	}
+	if res := reserve("tx2", "r1", "6", now.Add(15*time.Minute)); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if res := reserve("tx3", "r2", "5", now.Add(time.Hour)); res.Status == shim.OK {
+		t.Fatal("reservation exceeding available stock should be rejected")
+	}
+	if res := reserve("tx4", "r2", "4", now.Add(time.Hour)); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if stock := availableStock(t, mockStub, now); stock.Reserved != "10.000" || stock.Available != "0.000" {
+		t.Fatal(stock)
+	}
+	if res := invokeAt(mockStub, now, "tx5", "updateGoodsAmount", "s1", "1", "0"); res.Status == shim.OK {
+		t.Fatal("reserved stock should not be decremented")
+	}
+
+	// r1 过期后不再占用库存，且不能确认
+	later := now.Add(30 * time.Minute)
+	if stock := availableStock(t, mockStub, later); stock.Reserved != "4.000" || stock.Available != "6.000" {
+		t.Fatal(stock)
+	}
+	if res := invokeAt(mockStub, later, "tx6", "confirmReservation", "s1", "r1"); res.Status == shim.OK {
+		t.Fatal("expired reservation should not be confirmed")
+	}
+	if res := invokeAt(mockStub, later, "tx7", "releaseReservation", "s1", "r1"); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if res := invokeAt(mockStub, later, "tx8", "confirmReservation", "s1", "r2", "1"); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if res := invokeAt(mockStub, later, "tx9", "releaseReservation", "s1", "r2"); res.Status == shim.OK {
+		t.Fatal("confirmed reservation should not be released")
+	}
+	if stock := availableStock(t, mockStub, later); stock.StockNum != "6.000" || stock.Reserved != "0.000" {
+		t.Fatal(stock)
+	}
+
+	res := invokeAt(mockStub, later, "query", "queryReservations", "s1")
+	reservations := make([]Reservation, 0)
+	_ = json.Unmarshal(res.Payload, &reservations)
+	if len(reservations) != 2 || reservations[0].Status != ReservationReleased || reservations[1].Status != ReservationConfirmed {
+		t.Fatal(string(res.Payload))
+	}
+}
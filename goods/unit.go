@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// 计量单位按量纲换算：每个量纲有一个内置的基本单位，其他单位记录 1 单位等于多少基本单位
+const (
+	unitObjectType = "unit"
+
+	DimensionMass  = "mass"
+	DimensionCount = "count"
+
+	unitFactorScale = maxDecimalScale // 换算系数的小数位数
+)
+
+// 内置的基本单位，不能修改
+var baseUnits = map[string]*Unit{
+	DimensionMass:  {Code: "kg", Name: "千克", Dimension: DimensionMass, Factor: "1.000000"},
+	DimensionCount: {Code: "piece", Name: "件", Dimension: DimensionCount, Factor: "1.000000"},
+}
+
+type Unit struct {
+	Code      string `json:"code"`      // 单位编码，主键，如 jin
+	Name      string `json:"name"`      // 名称，如 斤
+	Dimension string `json:"dimension"` // 量纲 mass 或 count
+	Factor    string `json:"factor"`    // 1 单位等于多少基本单位，正数，最多 6 位小数，如 jin 为 0.5
+}
+
+// 新增计量单位，需由 admin 属性为 true 的身份调用
+// 已存储的数量按单位解释，因此已有单位只能修改名称，不能修改量纲和换算系数
+// value string required {"code","name","dimension","factor"}
+func setUnit(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("args length should be 1")
+	}
+	err := cid.AssertAttributeValue(stub, "admin", "true")
+	if err != nil {
+		return shim.Error("only admin can do this: " + err.Error())
+	}
+	unit := Unit{}
+	err = json.Unmarshal([]byte(args[0]), &unit)
+	if err != nil {
+		return shim.Error("unmarshal unit failed" + err.Error())
+	}
+	if unit.Code == "" {
+		return shim.Error("code is required")
+	}
+	if _, ok := baseUnits[unit.Dimension]; !ok {
+		return shim.Error("dimension should be mass or count, get " + unit.Dimension)
+	}
+	factor, err := parseDecimal("factor", unit.Factor, unitFactorScale)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if factor == 0 {
+		return shim.Error("factor should be greater than 0")
+	}
+	unit.Factor = formatDecimal(factor, unitFactorScale)
+
+	existing, err := getUnit(stub, unit.Code)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if existing != nil {
+		if isBaseUnit(existing) {
+			return shim.Error("base unit " + unit.Code + " can not be changed")
+		}
+		if existing.Dimension != unit.Dimension || existing.Factor != unit.Factor {
+			return shim.Error("dimension and factor of unit " + unit.Code + " can not be changed")
+		}
+	}
+	key, err := stub.CreateCompositeKey(unitObjectType, []string{unit.Code})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	jsonVal, err := json.Marshal(&unit)
+	if err != nil {
+		return shim.Error("failed to marshal unit:" + err.Error())
+	}
+	err = stub.PutState(key, jsonVal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success([]byte(stub.GetTxID()))
+}
+
+// 查询全部计量单位，基本单位在前
+// res : [Unit]
+func queryUnits(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 0 {
+		return shim.Error("args length should be 0")
+	}
+	data := []Unit{*baseUnits[DimensionMass], *baseUnits[DimensionCount]}
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(unitObjectType, []string{})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error("failed get resultsIterator:" + err.Error())
+		}
+		unit := Unit{}
+		err = json.Unmarshal(queryResponse.Value, &unit)
+		if err != nil {
+			return shim.Error("failed to unmarshal unit:" + err.Error())
+		}
+		data = append(data, unit)
+	}
+	res, err := json.Marshal(&data)
+	if err != nil {
+		return shim.Error("failed to marshal res" + err.Error())
+	}
+	return shim.Success(res)
+}
+
+func isBaseUnit(unit *Unit) bool {
+	return baseUnits[unit.Dimension].Code == unit.Code
+}
+
+// 根据编码获取单位，包括内置的基本单位；不存在时返回 nil
+func getUnit(stub shim.ChaincodeStubInterface, code string) (*Unit, error) {
+	for _, unit := range baseUnits {
+		if unit.Code == code {
+			return unit, nil
+		}
+	}
+	key, err := stub.CreateCompositeKey(unitObjectType, []string{code})
+	if err != nil {
+		return nil, err
+	}
+	jsonVal, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if jsonVal == nil {
+		return nil, nil
+	}
+	unit := Unit{}
+	err = json.Unmarshal(jsonVal, &unit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal unit %s: %s", code, err.Error())
+	}
+	return &unit, nil
+}
+
+// 获取已登记的单位，code 为空时返回 nil，表示引入单位之前的记录，不做换算
+func lookupUnit(stub shim.ChaincodeStubInterface, code string) (*Unit, error) {
+	if code == "" {
+		return nil, nil
+	}
+	unit, err := getUnit(stub, code)
+	if err != nil {
+		return nil, err
+	}
+	if unit == nil {
+		return nil, fmt.Errorf("unit %s is not registered", code)
+	}
+	return unit, nil
+}
+
+func checkUnitsCompatible(from *Unit, to *Unit) error {
+	if from != nil && to != nil && from.Dimension != to.Dimension {
+		return fmt.Errorf("unit %s (%s) is not compatible with unit %s (%s)", from.Code, from.Dimension, to.Code, to.Dimension)
+	}
+	return nil
+}
+
+// 将以 unitCode 为单位的数量换算为 goods 的单位，unitCode 为空时视为 goods 的单位
+func toGoodsUnits(stub shim.ChaincodeStubInterface, goods *Goods, units int64, scale int, unitCode string) (int64, error) {
+	if unitCode == "" || unitCode == goods.Unit {
+		return units, nil
+	}
+	if goods.Unit == "" {
+		return 0, fmt.Errorf("goods %s has no unit, quantity can not be given in %s", goods.StockId, unitCode)
+	}
+	from, err := lookupUnit(stub, unitCode)
+	if err != nil {
+		return 0, err
+	}
+	to, err := lookupUnit(stub, goods.Unit)
+	if err != nil {
+		return 0, err
+	}
+	return convertQuantity(units, scale, from, to)
+}
+
+// 将 from 单位的数量换算为 to 单位，units 为放大 10^scale 倍后的整数；换算结果超出 scale 位小数时报错而不是舍入
+func convertQuantity(units int64, scale int, from *Unit, to *Unit) (int64, error) {
+	if from.Code == to.Code {
+		return units, nil
+	}
+	err := checkUnitsCompatible(from, to)
+	if err != nil {
+		return 0, err
+	}
+	fromFactor, err := parseDecimal("factor", from.Factor, unitFactorScale)
+	if err != nil {
+		return 0, err
+	}
+	toFactor, err := parseDecimal("factor", to.Factor, unitFactorScale)
+	if err != nil {
+		return 0, err
+	}
+	quotient, remainder := new(big.Int).QuoRem(
+		new(big.Int).Mul(big.NewInt(units), big.NewInt(fromFactor)), big.NewInt(toFactor), new(big.Int))
+	if remainder.Sign() != 0 || !quotient.IsInt64() {
+		return 0, fmt.Errorf("%s %s can not be converted to %s with %d decimal places",
+			formatDecimal(units, scale), from.Code, to.Code, scale)
+	}
+	return quotient.Int64(), nil
+}
+
+// 将单价换算为每基本单位的价格，小数位数不变，四舍五入；单位为空时不换算
+func pricePerBaseUnit(units int64, unit *Unit) (int64, error) {
+	if unit == nil {
+		return units, nil
+	}
+	factor, err := parseDecimal("factor", unit.Factor, unitFactorScale)
+	if err != nil {
+		return 0, err
+	}
+	base := new(big.Int).Exp(big.NewInt(10), big.NewInt(unitFactorScale), nil)
+	// (2 * units * base + factor) / (2 * factor)
+	numerator := new(big.Int).Mul(big.NewInt(2*units), base)
+	numerator.Add(numerator, big.NewInt(factor))
+	quotient := numerator.Quo(numerator, big.NewInt(2*factor))
+	if !quotient.IsInt64() {
+		return 0, fmt.Errorf("price per %s overflows", baseUnits[unit.Dimension].Code)
+	}
+	return quotient.Int64(), nil
+}
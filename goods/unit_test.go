@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestConvertQuantity(t *testing.T) {
+	jin := &Unit{Code: "jin", Dimension: DimensionMass, Factor: "0.500000"}
+	gram := &Unit{Code: "g", Dimension: DimensionMass, Factor: "0.001000"}
+	kg := baseUnits[DimensionMass]
+	cases := []struct {
+		units    int64
+		from, to *Unit
+		expected int64
+		ok       bool
+	}{
+		{3000, jin, kg, 1500, true},
+		{1500, kg, jin, 3000, true},
+		{1000, gram, kg, 1, true},
+		{1, gram, kg, 0, false}, // 0.001 g 超出 3 位小数
+		{1000, kg, baseUnits[DimensionCount], 0, false},
+	}
+	for _, c := range cases {
+		units, err := convertQuantity(c.units, 3, c.from, c.to)
+		if (err == nil) != c.ok || units != c.expected {
+			t.Fatal(c.units, c.from.Code, c.to.Code, units, err)
+		}
+	}
+	if price, _ := pricePerBaseUnit(1001, jin); price != 2002 {
+		t.Fatal(price)
+	}
+	if price, _ := pricePerBaseUnit(1, gram); price != 1000 {
+		t.Fatal(price)
+	}
+}
+
+func TestUnits(t *testing.T) {
+	mockStub := shim.NewMockStub("goods", new(GoodsContract))
+	orders := &fakeOrderContract{orders: map[string]string{}}
+	mockStub.MockPeerChaincode(orderContractName, shim.NewMockStub(orderContractName, orders))
+	now := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	admin := newCreator("Org1MSP", "admin", map[string]string{"admin": "true"})
+
+	jin := `{"code":"jin","name":"斤","dimension":"mass","factor":"0.5"}`
+	if res := invokeAt(mockStub, now, "tx1", "setUnit", jin); res.Status == shim.OK {
+		t.Fatal("only admin can set units")
+	}
+	if res := invokeAs(mockStub, admin, now, "tx2", "setUnit", jin); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if res := invokeAs(mockStub, admin, now, "tx3", "setUnit", `{"code":"jin","name":"斤","dimension":"mass","factor":"0.6"}`); res.Status == shim.OK {
+		t.Fatal("factor of an existing unit should not be changed")
+	}
+	if res := invokeAs(mockStub, admin, now, "tx4", "setUnit", `{"code":"kg","name":"公斤","dimension":"mass","factor":"1"}`); res.Status == shim.OK {
+		t.Fatal("base unit should not be changed")
+	}
+	res := invokeAt(mockStub, now, "query", "queryUnits")
+	units := make([]Unit, 0)
+	_ = json.Unmarshal(res.Payload, &units)
+	if len(units) != 3 || units[2].Code != "jin" || units[2].Factor != "0.500000" {
+		t.Fatal(string(res.Payload))
+	}
+
+	if res := invokeAt(mockStub, now, "tx5", "addGoods", `{"stockId":"s1","stockNum":"10","unit":"liang"}`); res.Status == shim.OK {
+		t.Fatal("unregistered unit should be rejected")
+	}
+	if res := invokeAt(mockStub, now, "tx6", "addGoods", `{"stockId":"s1","goodsId":"g1","marketName":"m1","stockNum":"10","price":"20","unit":"kg"}`); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+
+	// 库存以 kg 计，按斤加减和预留时换算
+	if res := invokeAt(mockStub, now, "tx7", "updateGoodsAmount", "s1", "4", "1", "", "jin"); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if res := invokeAt(mockStub, now, "tx8", "updateGoodsAmount", "s1", "1", "0", "", "piece"); res.Status == shim.OK {
+		t.Fatal("incompatible unit should be rejected")
+	}
+	value := `{"reservationId":"r1","stockId":"s1","buyer":"b1","quantity":"6","unit":"jin","expireTime":"` + now.Add(time.Hour).Format(time.RFC3339) + `"}`
+	if res := invokeAt(mockStub, now, "tx9", "reserveGoods", value); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if stock := availableStock(t, mockStub, now); stock.StockNum != "12.000" || stock.Reserved != "3.000" || stock.Unit != "kg" {
+		t.Fatal(stock)
+	}
+	if res := invokeAt(mockStub, now, "tx10", "addGoods", `{"stockId":"s1","stockNum":"24","unit":"jin"}`); res.Status == shim.OK {
+		t.Fatal("unit should not be changed while reservations are held")
+	}
+
+	// 订单的单位需与商品兼容，为空时取商品的单位
+	if res := invokeAt(mockStub, now, "tx11", "addOrder", `{"orderNo":"o1","goodsStockId":"s1","weight":"2","unit":"piece"}`); res.Status == shim.OK {
+		t.Fatal("order with incompatible unit should be rejected")
+	}
+	if res := invokeAt(mockStub, now, "tx12", "addOrder", `{"orderNo":"o1","goodsStockId":"s1","weight":"2"}`); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	order := map[string]string{}
+	_ = json.Unmarshal([]byte(orders.received[len(orders.received)-1]), &order)
+	if order["unit"] != "kg" || order["weight"] != "2.000" {
+		t.Fatal(order)
+	}
+	orders.orders["o1"] = `{"orderNo":"o1","goodsStockId":"s1","unit":"kg"}`
+	if res := invokeAt(mockStub, now, "tx13", "updateOrder", `{"orderNo":"o1","unit":"piece"}`); res.Status == shim.OK {
+		t.Fatal("order unit should stay compatible with the stored goodsStockId")
+	}
+	if res := invokeAt(mockStub, now, "tx14", "updateOrder", `{"orderNo":"o1","unit":"jin"}`); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+
+	// 价格走势按每 kg 的价格统计
+	if res := invokeAt(mockStub, now, "tx15", "addGoods", `{"stockId":"s2","goodsId":"g1","marketName":"m1","price":"9","unit":"jin"}`); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	res = invokeAt(mockStub, now, "query", "queryPriceTrend", "g1", "m1", "2024-05-01", "2024-05-01")
+	trend := make([]DailyPrice, 0)
+	_ = json.Unmarshal(res.Payload, &trend)
+	if len(trend) != 1 || trend[0] != (DailyPrice{Date: "2024-05-01", Unit: "kg", Min: "18.00", Avg: "19.00", Max: "20.00", Count: 2}) {
+		t.Fatal(string(res.Payload))
+	}
+}
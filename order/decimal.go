@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// 小数位数由 goods 链码配置并在转发前格式化，这里只校验格式，防止直接调用时写入非法值
+var decimalPattern = regexp.MustCompile(`^\d{1,12}(\.\d{1,6})?$`)
+
+// 校验 amount 和 weight 为非负小数，为空时不校验
+func validateOrderDecimals(order *Order) error {
+	for field, value := range map[string]string{"amount": order.Amount, "weight": order.Weight} {
+		if value != "" && !decimalPattern.MatchString(value) {
+			return fmt.Errorf("%s should be a non-negative decimal with at most 12 integer digits and 6 decimal places, get %s", field, value)
+		}
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"strconv"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	"github.com/hyperledger/fabric/protos/peer"
@@ -12,6 +13,10 @@ import (
 
 const (
 	ORDER_ID = "orderNo"
+
+	OrderOpen      = "open"
+	OrderCompleted = "completed"
+	OrderCancelled = "cancelled"
 )
 
 var ErrorNotFound = fmt.Sprint("record not found")
@@ -26,6 +31,7 @@ type Order struct {
 	GoodsId      string `json:"goodsId"`      // 商品编号
 	Amount       string `json:"amount"`       // 金额
 	Weight       string `json:"weight"`       // 重量
+	Unit         string `json:"unit"`         // 重量的计量单位，通过 goods 调用时校验与商品的单位兼容
 	SellerShopId string `json:"sellerShopId"` // 商家摊位号
 	SellerShop   string `json:"sellerShop"`   // 摊位名称
 	SellerId     string `json:"sellerId"`     // 卖家id
@@ -37,6 +43,8 @@ type Order struct {
 	GoodsStockId string `json:"goodsStockId"` // 商品库存编号
 	TranTime     string `json:"tranTime"`     // 交易时间
 	SubmitTime   string `json:"submitTime"`   // 提交时间
+	Status       string `json:"status"`       // 订单状态 open 未完成，completed 已完成，cancelled 已取消；新订单默认 open，为空的只有引入状态之前的订单，无法判断是否完成
+	Version      int64  `json:"version"`      // 版本，每次写入加 1，不需要传入
 }
 
 type Pagination struct {
@@ -61,16 +69,26 @@ func (t Chaincode) Invoke(stub shim.ChaincodeStubInterface) peer.Response {
 		return updateOrder(stub, args)
 	case "queryOrder":
 		return queryOrder(stub, args)
+	case "queryOpenOrders":
+		return queryOpenOrders(stub, args)
+	case "queryOrderByOrderNo":
+		return queryOrderByOrderNo(stub, args)
 	default:
 		return shim.Error("unsupported method " + fn)
 	}
 }
 
+// orderNo 为主键，orderNo 已存在时覆盖原记录
+// expectedVersion string 可选，覆盖时与当前版本不一致则报错
 func addOrder(stub shim.ChaincodeStubInterface, args []string) peer.Response {
-	if len(args) != 1 {
-		return shim.Error("args length should be 1")
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("args length should be 1 or 2")
 	}
 	jsonValue := args[0]
+	expectedVersion := ""
+	if len(args) == 2 {
+		expectedVersion = args[1]
+	}
 	order := Order{}
 	err := json.Unmarshal([]byte(jsonValue), &order)
 	if err != nil {
@@ -80,7 +98,30 @@ func addOrder(stub shim.ChaincodeStubInterface, args []string) peer.Response {
 	if id == "" {
 		return shim.Error("orderNo is required")
 	}
-	err = stub.PutState(id, []byte(jsonValue))
+	err = validateOrder(&order)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	existing, err := getOrder(stub, id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	order.Version = 0
+	if existing != nil {
+		order.Version = existing.Version
+	}
+	// 为空的状态只保留给引入状态之前的订单，新订单默认未完成，覆盖时沿用原状态
+	if order.Status == "" {
+		order.Status = OrderOpen
+		if existing != nil {
+			order.Status = existing.Status
+		}
+	}
+	err = checkVersion(id, order.Version, expectedVersion)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putOrder(stub, &order)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -88,36 +129,50 @@ func addOrder(stub shim.ChaincodeStubInterface, args []string) peer.Response {
 }
 
 // orderNo 为主键来更新
+// expectedVersion string 可选，与当前版本不一致时报错
 func updateOrder(stub shim.ChaincodeStubInterface, args []string) peer.Response {
-	if len(args) != 1 {
-		return shim.Error("args length should be 1")
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("args length should be 1 or 2")
 	}
 	jsonValue := args[0]
+	expectedVersion := ""
+	if len(args) == 2 {
+		expectedVersion = args[1]
+	}
 	orderMap := make(map[string]string)
 	err := json.Unmarshal([]byte(jsonValue), &orderMap)
 	if err != nil {
 		return shim.Error("failed to unmarshal map:" + err.Error())
 	}
-	order := Order{}
-	if id, ok := orderMap[ORDER_ID]; !ok {
+	if _, ok := orderMap["version"]; ok {
+		return shim.Error("version is maintained by the chaincode, pass expectedVersion instead")
+	}
+	id, ok := orderMap[ORDER_ID]
+	if !ok {
 		return shim.Error("orderNo is required")
-	} else {
-		orderJson, err := stub.GetState(id)
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-		err = json.Unmarshal(orderJson, &order)
-		if err != nil {
-			return shim.Error("failed to unmarshal order:" + err.Error())
-		}
+	}
+	order, err := getOrder(stub, id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if order == nil {
+		return shim.Error(ErrorNotFound)
+	}
+	err = checkVersion(id, order.Version, expectedVersion)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 
-	update := mergeStructAndMap(&order, orderMap).(*Order)
-	updateOrder, err := json.Marshal(update)
+	previousStatus := order.Status
+	update := mergeStructAndMap(order, orderMap).(*Order)
+	if update.Status == "" && previousStatus != "" {
+		return shim.Error("status can not be cleared")
+	}
+	err = validateOrder(update)
 	if err != nil {
-		return shim.Error("failed to marshal order:" + err.Error())
+		return shim.Error(err.Error())
 	}
-	err = stub.PutState(order.OrderNo, updateOrder)
+	err = putOrder(stub, update)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -163,12 +218,137 @@ func queryOrder(stub shim.ChaincodeStubInterface, args []string) peer.Response {
 	return shim.Success(buffer.Bytes())
 }
 
+// 根据 orderNo 查询订单
+// orderNo string required
+// res : Order
+func queryOrderByOrderNo(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("should have only 1 args")
+	}
+	order, err := getOrder(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if order == nil {
+		return shim.Error(ErrorNotFound)
+	}
+	res, err := json.Marshal(order)
+	if err != nil {
+		return shim.Error("failed to marshal res" + err.Error())
+	}
+	return shim.Success(res)
+}
+
+// 根据 goodsStockId 查询未完成的订单，goods 下架前调用；没有状态的旧订单一并返回，由调用方区分
+// goodsStockId string required
+// res : [Order]
+func queryOpenOrders(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 1 {
+		return shim.Error("should have only 1 args")
+	}
+	query, err := buildOpenOrdersQuery(args[0])
+	if err != nil {
+		return shim.Error("failed to generate query string:" + err.Error())
+	}
+
+	orderIterator, err := stub.GetQueryResult(query)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer orderIterator.Close()
+
+	data := make([]Order, 0)
+	for orderIterator.HasNext() {
+		queryResponse, err := orderIterator.Next()
+		if err != nil {
+			return shim.Error("failed to get order")
+		}
+		order := Order{}
+		err = json.Unmarshal(queryResponse.Value, &order)
+		if err != nil {
+			return shim.Error("failed to unmarshal order:" + err.Error())
+		}
+		data = append(data, order)
+	}
+	res, err := json.Marshal(&data)
+	if err != nil {
+		return shim.Error("failed to marshal res" + err.Error())
+	}
+	return shim.Success(res)
+}
+
+// 未完成的订单包括 status 为 open 的订单，以及没有状态、无法判断是否完成的旧订单（status 为空或没有该字段）
+// 索引只包含 goodsStockId，没有 status 字段的订单也在索引中
+func buildOpenOrdersQuery(goodsStockId string) (string, error) {
+	queryMap := map[string]interface{}{
+		"selector": map[string]interface{}{
+			"goodsStockId": map[string]string{
+				"$eq": goodsStockId,
+			},
+			"$or": []map[string]interface{}{
+				{"status": map[string][]string{"$in": {OrderOpen, ""}}},
+				{"status": map[string]bool{"$exists": false}},
+			},
+		},
+		"sort":      []map[string]string{{"goodsStockId": "asc"}},
+		"use_index": []string{"_design/orderGoodsStockIdDoc", "goodsStockId"},
+	}
+	query, err := json.Marshal(&queryMap)
+	if err != nil {
+		return "", err
+	}
+	return string(query), nil
+}
+
+// 校验订单状态和小数字段
+func validateOrder(order *Order) error {
+	switch order.Status {
+	case "", OrderOpen, OrderCompleted, OrderCancelled:
+	default:
+		return fmt.Errorf("status should be open, completed or cancelled, get %s", order.Status)
+	}
+	return validateOrderDecimals(order)
+}
+
+func generateQueryString(equal map[string]string, regex map[string]string, sort map[string]string, index []string) (string, error) {
+	selectorMap := make(map[string]map[string]string)
+	for key, val := range equal {
+		selectorMap[key] = map[string]string{
+			"$eq": val,
+		}
+	}
+
+	for key, val := range regex {
+		selectorMap[key] = map[string]string{
+			"$regex": val,
+		}
+	}
+
+	queryMap := map[string]interface{}{
+		"selector": selectorMap,
+		"sort": []map[string]string{
+			sort,
+		},
+		"use_index": index,
+	}
+
+	query, err := json.Marshal(&queryMap)
+
+	if err != nil {
+		return "", err
+	}
+	return string(query), nil
+}
+
 func mergeStructAndMap(point interface{}, jsonMap map[string]string) interface{} {
 	orderType := reflect.TypeOf(point).Elem()
 	orderValue := reflect.ValueOf(point).Elem()
 	for i := 0; i < orderType.NumField(); i++ {
 		field := orderType.Field(i)
 		jsonTag := field.Tag.Get("json")
+		if field.Type.Kind() != reflect.String {
+			continue
+		}
 		if val, ok := jsonMap[jsonTag]; ok {
 			orderValue.FieldByName(field.Name).SetString(val)
 		}
@@ -176,6 +356,48 @@ func mergeStructAndMap(point interface{}, jsonMap map[string]string) interface{}
 	return point
 }
 
+// 根据 orderNo 获取 order，不存在时返回 nil
+func getOrder(stub shim.ChaincodeStubInterface, orderNo string) (*Order, error) {
+	jsonVal, err := stub.GetState(orderNo)
+	if err != nil {
+		return nil, err
+	}
+	if jsonVal == nil {
+		return nil, nil
+	}
+	order := Order{}
+	err = json.Unmarshal(jsonVal, &order)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal order %s: %s", orderNo, err.Error())
+	}
+	return &order, nil
+}
+
+// 调用方持有读取时的 order，写入时版本加 1
+func putOrder(stub shim.ChaincodeStubInterface, order *Order) error {
+	order.Version++
+	jsonVal, err := json.Marshal(order)
+	if err != nil {
+		return fmt.Errorf("failed to marshal order: %s", err.Error())
+	}
+	return stub.PutState(order.OrderNo, jsonVal)
+}
+
+// 校验调用方传入的 expectedVersion，为空时不校验；引入版本之前写入的记录版本为 0
+func checkVersion(id string, version int64, expectedVersion string) error {
+	if expectedVersion == "" {
+		return nil
+	}
+	expected, err := strconv.ParseInt(expectedVersion, 10, 64)
+	if err != nil {
+		return fmt.Errorf("expectedVersion should be an integer, get %s", expectedVersion)
+	}
+	if expected != version {
+		return fmt.Errorf("version conflict: %s is at version %d, expected %d", id, version, expected)
+	}
+	return nil
+}
+
 func main() {
 	if err := shim.Start(new(Chaincode)); err != nil {
 		fmt.Printf("Error starting SimpleAsset chaincode: %s", err)
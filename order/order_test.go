@@ -7,6 +7,7 @@ import (
 	"regexp"
 	"testing"
 
+	"github.com/hyperledger/fabric/core/chaincode/shim"
 )
 
 func TestReflectField(t *testing.T)  {
@@ -118,3 +119,49 @@ func TestReg(t *testing.T)  {
 	re := regexp.MustCompile("\"b\":\"(.*?)\\\"")
 	t.Log(re.FindString(str))
 }
+
+func TestOrderStatus(t *testing.T) {
+	mockStub := shim.NewMockStub("order", new(Chaincode))
+	if res := mockStub.MockInvoke("tx1", [][]byte{[]byte("addOrder"), []byte(`{"orderNo":"o1","goodsStockId":"s1"}`)}); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	order, _ := getOrder(mockStub, "o1")
+	if order.Status != OrderOpen {
+		t.Fatal("new order should default to open, get " + order.Status)
+	}
+	if res := mockStub.MockInvoke("tx2", [][]byte{[]byte("updateOrder"), []byte(`{"orderNo":"o1","status":""}`)}); res.Status == shim.OK {
+		t.Fatal("status should not be cleared")
+	}
+	if res := mockStub.MockInvoke("tx3", [][]byte{[]byte("updateOrder"), []byte(`{"orderNo":"o1","status":"completed"}`)}); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if res := mockStub.MockInvoke("tx4", [][]byte{[]byte("addOrder"), []byte(`{"orderNo":"o1","goodsStockId":"s1"}`)}); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if order, _ = getOrder(mockStub, "o1"); order.Status != OrderCompleted {
+		t.Fatal("overwriting without status should keep the stored status, get " + order.Status)
+	}
+}
+
+func TestOpenOrdersQuery(t *testing.T) {
+	query, err := buildOpenOrdersQuery("s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `{"selector":{"$or":[{"status":{"$in":["open",""]}},{"status":{"$exists":false}}],"goodsStockId":{"$eq":"s1"}},"sort":[{"goodsStockId":"asc"}],"use_index":["_design/orderGoodsStockIdDoc","goodsStockId"]}`
+	if query != expect {
+		t.Fatal(query)
+	}
+
+	// 引入状态之前的订单没有 status 字段，可以通过 updateOrder 设置明确的状态
+	mockStub := shim.NewMockStub("order", new(Chaincode))
+	mockStub.MockTransactionStart("tx1")
+	_ = mockStub.PutState("o1", []byte(`{"orderNo":"o1","goodsStockId":"s1"}`))
+	mockStub.MockTransactionEnd("tx1")
+	if res := mockStub.MockInvoke("tx2", [][]byte{[]byte("updateOrder"), []byte(`{"orderNo":"o1","status":"cancelled"}`)}); res.Status != shim.OK {
+		t.Fatal(res.Message)
+	}
+	if order, _ := getOrder(mockStub, "o1"); order.Status != OrderCancelled {
+		t.Fatal(order)
+	}
+}